@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// commit as served by the read api; anything not harvested yet is null
+type apiCommit struct {
+	Id                 string  `json:"id"`
+	Org                string  `json:"org"`
+	Repo               string  `json:"repo"`
+	Sha                string  `json:"sha"`
+	Email              *string `json:"email"`
+	Date               *string `json:"date"`
+	Message            *string `json:"message"`
+	Additions          *int    `json:"additions"`
+	Deletions          *int    `json:"deletions"`
+	Total              *int    `json:"total"`
+	Name               *string `json:"name"`
+	Login              *string `json:"login"`
+	CommitterEmail     *string `json:"committer_email"`
+	CommitterDate      *string `json:"committer_date"`
+	Verified           *bool   `json:"verified"`
+	VerificationReason *string `json:"verification_reason"`
+}
+
+// pull as served by the read api
+type apiPull struct {
+	Id        string  `json:"id"`
+	Org       string  `json:"org"`
+	Repo      string  `json:"repo"`
+	Number    int     `json:"number"`
+	Title     *string `json:"title"`
+	Comments  *int    `json:"comments"`
+	Commits   *int    `json:"commits"`
+	Additions *int    `json:"additions"`
+	Deletions *int    `json:"deletions"`
+	Changed   *int    `json:"changed_files"`
+	State     *string `json:"state"`
+	Login     *string `json:"login"`
+	CreatedAt *string `json:"created_at"`
+	MergedAt  *string `json:"merged_at"`
+	ClosedAt  *string `json:"closed_at"`
+}
+
+// serve read only json on --api-addr, if set:
+//
+//	/repos/{repo}/commits?since=&until=&org=&after=&per_page=
+//	/repos/{repo}/pulls?since=&until=&org=&after=&per_page=
+//
+// pages by id, with a Link rel="next" header like github's
+func serveApi(cfg *Config, st Store) {
+	if cfg.ApiAddr == "" {
+		return
+	}
+
+	s, ok := st.(*sqlStore)
+	if !ok {
+		log.Fatal("--api-addr needs a database, not --output json or --store mem")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/", s.apiRepos(cfg))
+	go func() {
+		infof("fn=serveApi addr=%v\n", cfg.ApiAddr)
+		log.Fatal(http.ListenAndServe(cfg.ApiAddr, mux))
+	}()
+}
+
+// /repos/ request processing
+func (s *sqlStore) apiRepos(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[1] == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		org := q.Get("org")
+		if org == "" {
+			org = cfg.Orgs[0]
+		}
+
+		n := 100
+		if v := q.Get("per_page"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 1 || n > 1000 {
+				http.Error(w, "per_page must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var (
+			rows  interface{}
+			last  string
+			count int
+			err   error
+		)
+		switch parts[2] {
+		case "commits":
+			var commits []apiCommit
+			commits, err = s.apiCommits(r, org, parts[1], q, n)
+			if count = len(commits); count > 0 {
+				last = commits[count-1].Id
+			}
+			rows = commits
+		case "pulls":
+			var pulls []apiPull
+			pulls, err = s.apiPulls(r, org, parts[1], q, n)
+			if count = len(pulls); count > 0 {
+				last = pulls[count-1].Id
+			}
+			rows = pulls
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			warnf("fn=apiRepos url=%q err=%v\n", r.URL, err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		// a full page may have more after it
+		if count == n {
+			q.Set("after", last)
+			w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, q.Encode()))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	}
+}
+
+// where clause for a repo page, with optional since/until on column
+func (s *sqlStore) apiWhere(org, repo, column string, q url.Values, n int) (string, []interface{}) {
+	where := "org=$1 AND repo=$2 AND id > $3"
+	args := []interface{}{org, repo, s.after(q.Get("after"))}
+	if v := q.Get("since"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND %s >= $%d", column, len(args))
+	}
+	if v := q.Get("until"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND %s <= $%d", column, len(args))
+	}
+	args = append(args, n)
+
+	return fmt.Sprintf("%s ORDER BY id LIMIT $%d", where, len(args)), args
+}
+
+func (s *sqlStore) apiCommits(r *http.Request, org, repo string, q url.Values, n int) ([]apiCommit, error) {
+	where, args := s.apiWhere(org, repo, "date", q, n)
+	rows, err := s.db.QueryContext(r.Context(), s.q("SELECT id, org, repo, sha, email, date, msg, adds, dels, total, name, login, committer_email, committer_date, verified, verification_reason FROM {prefix}commits WHERE ")+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commits := []apiCommit{}
+	for rows.Next() {
+		var c apiCommit
+		if err := rows.Scan(&c.Id, &c.Org, &c.Repo, &c.Sha, &c.Email, &c.Date, &c.Message, &c.Additions, &c.Deletions, &c.Total, &c.Name, &c.Login, &c.CommitterEmail, &c.CommitterDate, &c.Verified, &c.VerificationReason); err != nil {
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+
+	return commits, rows.Err()
+}
+
+func (s *sqlStore) apiPulls(r *http.Request, org, repo string, q url.Values, n int) ([]apiPull, error) {
+	where, args := s.apiWhere(org, repo, "created_at", q, n)
+	rows, err := s.db.QueryContext(r.Context(), s.q("SELECT id, org, repo, number, title, comments, commits, adds, dels, changed, state, login, created_at, merged_at, closed_at FROM {prefix}pulls WHERE ")+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pulls := []apiPull{}
+	for rows.Next() {
+		var p apiPull
+		if err := rows.Scan(&p.Id, &p.Org, &p.Repo, &p.Number, &p.Title, &p.Comments, &p.Commits, &p.Additions, &p.Deletions, &p.Changed, &p.State, &p.Login, &p.CreatedAt, &p.MergedAt, &p.ClosedAt); err != nil {
+			return nil, err
+		}
+		pulls = append(pulls, p)
+	}
+
+	return pulls, rows.Err()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// github app installation, whose tokens last an hour
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app
+type githubApp struct {
+	sync.Mutex
+	id           string
+	installation string
+	key          *rsa.PrivateKey
+	accept       string
+	token        string
+	expires      time.Time
+}
+
+// authorization header value, refreshing the installation token before it expires
+func (a *githubApp) auth() string {
+	a.Lock()
+	defer a.Unlock()
+
+	if time.Until(a.expires) < 5*time.Minute {
+		a.refresh()
+	}
+
+	return "token " + a.token
+}
+
+// signed RS256 jwt identifying the app, good for 10 minutes
+func (a *githubApp) jwt() string {
+	enc := base64.RawURLEncoding
+	now := time.Now()
+
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.id,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	unsigned := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(sig)
+}
+
+// exchange the jwt for an installation token
+// https://docs.github.com/en/rest/apps/apps#create-an-installation-access-token-for-an-app
+func (a *githubApp) refresh() {
+	url := fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", a.installation)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.jwt())
+	req.Header.Set("User-Agent", "prism/"+version)
+	req.Header.Set("Accept", a.accept)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		errorf("fn=refresh url=%v StatusCode=%v Body=%q\n", url, resp.StatusCode, body)
+		authFailed(url)
+	}
+
+	var result struct {
+		Token      string
+		Expires_at time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Fatal(err)
+	}
+
+	infof("fn=refresh installation=%v expires=%v\n", a.installation, result.Expires_at.Format(iso8601))
+	a.token, a.expires = result.Token, result.Expires_at
+}
+
+func makeApp(accept string) *githubApp {
+	block, _ := pem.Decode([]byte(mustGetenv("GITHUB_APP_PRIVATE_KEY")))
+	if block == nil {
+		log.Fatal("GITHUB_APP_PRIVATE_KEY is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			log.Fatal("GITHUB_APP_PRIVATE_KEY is not an RSA key")
+		}
+	}
+
+	return &githubApp{
+		id:           mustGetenv("GITHUB_APP_ID"),
+		installation: mustGetenv("GITHUB_APP_INSTALLATION_ID"),
+		key:          key,
+		accept:       accept,
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+)
+
+// media types of features that started out in preview, still asked for
+// in case of older enterprise servers
+const (
+	topicsPreview    = "application/vnd.github.mercy-preview+json"
+	checkRunsPreview = "application/vnd.github.antiope-preview+json"
+)
+
+// Config is everything a run is told from flags and the environment
+type Config struct {
+	Inserter        bool
+	Updater         bool
+	Loop            bool
+	Limit           int
+	Scale           int
+	InsertScale     int
+	UpdateScale     int
+	CommitScale     int
+	PullScale       int
+	CommitsOnly     bool
+	PullsOnly       bool
+	QueueSize       int
+	TaskTimeout     time.Duration
+	Delay           int
+	MinDelay        time.Duration
+	MaxDelay        time.Duration
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	Since           string
+	Until           string
+	Backfill        bool
+	BackfillChunk   time.Duration
+	Dependabot      bool
+	AllBranches     bool
+	Parents         bool
+	ResetMissing    bool
+	MaxAttempts     int
+	RetryDead       bool
+	StateFile       string
+	Full            bool
+	CodeScanning    bool
+	RepoLanguages   bool
+	RepoTopics      bool
+	WorkflowRuns    bool
+	CheckRuns       bool
+	Deployments     bool
+	Teams           bool
+	GraphqlCommits  bool
+	GraphqlRepos    bool
+	Accept          string
+	Proxy           string
+	RateReserve     int
+	MetricsAddr     string
+	EventWebhook    string
+	SlackWebhook    string
+	LogFormat       string
+	DryRun          bool
+	SSLMode         string
+	DBMaxOpen       int
+	DBMaxIdle       int
+	DBConnLifetime  time.Duration
+	Migrate         bool
+	LogLevel        string
+	Rate            float64
+	Output          string
+	Store           string
+	Raw             bool
+	Repos           string
+	SkipArchived    bool
+	SkipForks       bool
+	Languages       string
+	LanguagesEmpty  bool
+	RepoConcurrency int
+	PageSize        int
+	MaxPages        int
+	Deleter         bool
+	Confirm         bool
+	OwnerType       string
+	ApiAddr         string
+	Report          string
+	Top             int
+	Out             string
+	PprofAddr       string
+	HealthAddr      string
+	HealthStale     time.Duration
+	WebhookAddr     string
+	OtelEndpoint    string
+	TablePrefix     string
+	Anonymize       bool
+	Forget          string
+
+	Orgs          []string
+	EmailKey      []byte
+	WebhookSecret []byte
+	Ignores       repoSet
+	Includes      repoSet
+	Tokens        *tokenPool
+}
+
+// flags first, then the environment they don't cover
+func parseConfig() *Config {
+	c := &Config{}
+	flag.BoolVar(&c.Inserter, "inserter", false, "Insert Worker")
+	flag.BoolVar(&c.Updater, "updater", false, "Update Worker")
+	flag.BoolVar(&c.Loop, "loop", false, "Loop Worker")
+	flag.IntVar(&c.Limit, "limit", 1000, "Query Limit")
+	flag.IntVar(&c.Scale, "scale", 5, "Number of Workers")
+	flag.IntVar(&c.InsertScale, "insert-scale", 0, "Number of Insert Workers, 0 for --scale")
+	flag.IntVar(&c.UpdateScale, "update-scale", 0, "Number of Update Workers per Pool, 0 for --scale")
+	flag.IntVar(&c.CommitScale, "commit-scale", 0, "Number of Commit Update Workers, 0 for --update-scale")
+	flag.IntVar(&c.PullScale, "pull-scale", 0, "Number of Pull Update Workers, 0 for --update-scale")
+	flag.BoolVar(&c.CommitsOnly, "commits-only", false, "List and Update Commits but not Pulls")
+	flag.BoolVar(&c.PullsOnly, "pulls-only", false, "List and Update Pulls but not Commits")
+	flag.IntVar(&c.QueueSize, "queue-size", 100, "Tasks Buffered per Worker Channel, beyond One Running per Worker; Listing Waits while Full")
+	flag.DurationVar(&c.TaskTimeout, "task-timeout", 0, "Most Time a Task Spends on API Requests, 0 for no limit")
+	flag.IntVar(&c.Delay, "delay", 15, "Delay")
+	flag.DurationVar(&c.MinDelay, "min-delay", 0, "Least Sleep between Loops with --max-delay")
+	flag.DurationVar(&c.MaxDelay, "max-delay", 0, "Most Sleep between Loops, 0 for a fixed --delay")
+	flag.IntVar(&c.BreakerFailures, "breaker-failures", 5, "Consecutive Failures before Skipping a Repo Endpoint, 0 to never skip")
+	flag.DurationVar(&c.BreakerCooldown, "breaker-cooldown", time.Hour, "How Long a Failing Repo Endpoint is Skipped")
+	flag.StringVar(&c.Since, "since", "", "Since Timestamp")
+	flag.StringVar(&c.Until, "until", "", "Until Timestamp")
+	flag.BoolVar(&c.Backfill, "backfill", false, "List Commits from --since to --until a Chunk at a Time, Resuming after the Last Chunk Done")
+	flag.DurationVar(&c.BackfillChunk, "backfill-chunk", 7*24*time.Hour, "Window of each --backfill Chunk")
+	flag.BoolVar(&c.Dependabot, "dependabot", false, "Dependabot Alerts")
+	flag.BoolVar(&c.Parents, "parents", false, "Store Commit Parents")
+	flag.BoolVar(&c.AllBranches, "all-branches", false, "List Commits on Every Branch, not just the Default")
+	flag.BoolVar(&c.ResetMissing, "reset-missing", false, "Reset Missing Commits")
+	flag.IntVar(&c.MaxAttempts, "max-attempts", 5, "Failed Lookups before a Commit or Pull is Dead Lettered, 0 to never Give Up")
+	flag.BoolVar(&c.RetryDead, "retry-dead-letters", false, "Look up Dead Lettered Commits and Pulls Again")
+	flag.StringVar(&c.StateFile, "state-file", "", "State File")
+	flag.BoolVar(&c.Full, "full", false, "List Every Repo from the Start, Ignoring Saved Progress")
+	flag.BoolVar(&c.CodeScanning, "code-scanning", false, "Code Scanning Alerts")
+	flag.BoolVar(&c.RepoLanguages, "repo-languages", false, "Bytes per Language for each Repo")
+	flag.BoolVar(&c.RepoTopics, "repo-topics", false, "Topics for each Repo, from the Repo Listing")
+	flag.BoolVar(&c.WorkflowRuns, "workflow-runs", false, "GitHub Actions Workflow Runs, Created since --since")
+	flag.BoolVar(&c.CheckRuns, "check-runs", false, "Check Runs for each Commit, Looked up by the Updater")
+	flag.BoolVar(&c.Deployments, "deployments", false, "Deployments and their Statuses")
+	flag.BoolVar(&c.Teams, "teams", false, "Org Teams and their Members, once per Loop")
+	flag.BoolVar(&c.GraphqlCommits, "graphql-commits", false, "Look up Commits 100 at a Time over GraphQL, without Files")
+	flag.BoolVar(&c.GraphqlRepos, "graphql-repos", false, "List Repos over GraphQL")
+	flag.StringVar(&c.Accept, "accept", "application/vnd.github.v3+json", "Accept Media Type")
+	flag.StringVar(&c.Proxy, "proxy", "", "Proxy URL, overriding HTTPS_PROXY and NO_PROXY")
+	flag.IntVar(&c.RateReserve, "rate-reserve", 100, "Rate Limit Reserve")
+	flag.StringVar(&c.MetricsAddr, "metrics-addr", "", "Metrics Address")
+	flag.StringVar(&c.EventWebhook, "event-webhook", "", "URL to Post New Commits to")
+	flag.StringVar(&c.SlackWebhook, "slack-webhook", "", "Slack Webhook URL for Run Summaries and Fatal Errors")
+	flag.StringVar(&c.LogFormat, "log-format", "logfmt", "Log Format, logfmt or json")
+	flag.BoolVar(&c.DryRun, "dry-run", false, "Skip Database Writes")
+	flag.StringVar(&c.SSLMode, "db-sslmode", getenv("PGSSLMODE", "require"), "Database SSL Mode")
+	flag.IntVar(&c.DBMaxOpen, "db-max-open", 0, "Database Max Open Connections, 0 for Twice the Total Workers")
+	flag.IntVar(&c.DBMaxIdle, "db-max-idle", 0, "Database Max Idle Connections, 0 for --db-max-open")
+	flag.DurationVar(&c.DBConnLifetime, "db-conn-lifetime", 30*time.Minute, "Database Connection Lifetime")
+	flag.BoolVar(&c.Migrate, "migrate", false, "Apply Schema Migrations")
+	flag.StringVar(&c.LogLevel, "log-level", "info", "Log Level, debug, info, warn, or error")
+	flag.Float64Var(&c.Rate, "rate", 0, "Requests Per Second, 0 to pace from rate limit headers")
+	flag.StringVar(&c.Output, "output", "db", "Output, db or json lines on stdout")
+	flag.StringVar(&c.Store, "store", "db", "Store, db for --output, or mem to keep nothing past the run")
+	flag.BoolVar(&c.Raw, "raw", false, "Store Raw Commit and Pull JSON")
+	flag.StringVar(&c.Repos, "repos", "", "Comma Separated Repos, instead of listing the org")
+	flag.BoolVar(&c.SkipArchived, "skip-archived", false, "Skip Archived Repos")
+	flag.BoolVar(&c.SkipForks, "skip-forks", false, "Skip Forked Repos")
+	flag.StringVar(&c.Languages, "languages", "", "Comma Separated Primary Languages to Harvest, empty for all")
+	flag.BoolVar(&c.LanguagesEmpty, "languages-empty", false, "Harvest Repos with No Primary Language under --languages")
+	flag.IntVar(&c.RepoConcurrency, "repo-concurrency", 0, "Repos Paginated at Once, 0 for no limit")
+	flag.IntVar(&c.PageSize, "page-size", 100, "Items per Listing Page, at most 100")
+	flag.IntVar(&c.MaxPages, "max-pages", 1000, "Pages Followed per Listing")
+	flag.BoolVar(&c.Deleter, "deleter", false, "Delete Rows for Repos No Longer in the Org")
+	flag.BoolVar(&c.Confirm, "confirm", false, "Really Delete with --deleter")
+	flag.StringVar(&c.OwnerType, "owner-type", "org", "Owner Type of ORG, org or user")
+	flag.StringVar(&c.ApiAddr, "api-addr", "", "Read API Address")
+	flag.StringVar(&c.Report, "report", "", "Print a Report from Stored Data, committers or csv")
+	flag.IntVar(&c.Top, "top", 20, "Rows per Org in --report")
+	flag.StringVar(&c.Out, "out", "", "File for --report csv, stdout when empty")
+	flag.StringVar(&c.PprofAddr, "pprof-addr", "", "Profiling Address")
+	flag.StringVar(&c.HealthAddr, "health-addr", "", "Health Check Address, serving /healthz")
+	flag.DurationVar(&c.HealthStale, "health-stale", 90*time.Minute, "Unhealthy with --loop once no Task Finishes for this Long")
+	flag.StringVar(&c.WebhookAddr, "webhook-addr", "", "Receive Push and Pull Request Events here instead of Polling the Org")
+	flag.StringVar(&c.OtelEndpoint, "otel-endpoint", "", "OTLP/HTTP Trace Endpoint URL")
+	flag.StringVar(&c.TablePrefix, "table-prefix", os.Getenv("TABLE_PREFIX"), "Table Name Prefix, e.g. prod_")
+	flag.BoolVar(&c.Anonymize, "anonymize-emails", false, "Store Emails as HMACs keyed by EMAIL_HMAC_KEY")
+	flag.StringVar(&c.Forget, "forget", "", "Scrub Stored Commits by this Email and Exit")
+	flag.Parse()
+
+	c.Orgs = splitList(mustGetenv("ORG"))
+	c.Ignores = makeRepoSet(os.Getenv("IGNORE_REPOS"))
+	c.Includes = makeRepoSet(os.Getenv("INCLUDE_REPOS"))
+	if c.RepoTopics {
+		c.Accept = withPreview(c.Accept, topicsPreview)
+	}
+	if c.CheckRuns {
+		c.Accept = withPreview(c.Accept, checkRunsPreview)
+	}
+	c.Tokens = makeTokens(os.Getenv("OAUTH_TOKENS"), c.Accept)
+	if c.WebhookAddr != "" {
+		c.WebhookSecret = []byte(mustGetenv("WEBHOOK_SECRET"))
+	}
+	if c.Anonymize {
+		c.EmailKey = []byte(mustGetenv("EMAIL_HMAC_KEY"))
+	}
+
+	return c
+}
+
+// accept plus preview, unless it's there already
+func withPreview(accept, preview string) string {
+	if strings.Contains(accept, preview) {
+		return accept
+	}
+
+	return accept + ", " + preview
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// new sha as posted to --event-webhook
+type commitEvent struct {
+	Org  string `json:"org"`
+	Repo string `json:"repo"`
+	Sha  string `json:"sha"`
+}
+
+// most events in one post
+const eventBatch = 100
+
+// posts new shas to --event-webhook in batches, off the harvest's path;
+// when the webhook can't keep up events are dropped rather than waited on
+type eventSink struct {
+	url  string
+	c    chan commitEvent
+	done chan struct{}
+}
+
+// nil, which drops everything, without --event-webhook
+var events *eventSink
+
+func newEventSink(cfg *Config) *eventSink {
+	if cfg.EventWebhook == "" {
+		return nil
+	}
+
+	e := &eventSink{url: cfg.EventWebhook, c: make(chan commitEvent, cfg.QueueSize*eventBatch), done: make(chan struct{})}
+	go e.run()
+
+	return e
+}
+
+func (e *eventSink) emit(org, repo, sha string) {
+	if e == nil {
+		return
+	}
+
+	select {
+	case e.c <- commitEvent{Org: org, Repo: repo, Sha: sha}:
+	default:
+		warnf("fn=emit org=%v repo=%v sha=%v at=dropped\n", org, repo, sha)
+	}
+}
+
+// post what's left and stop
+func (e *eventSink) close() {
+	if e == nil {
+		return
+	}
+
+	close(e.c)
+	<-e.done
+}
+
+// a batch goes once full, or after a second of waiting
+func (e *eventSink) run() {
+	defer close(e.done)
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	var batch []commitEvent
+	for {
+		select {
+		case ev, ok := <-e.c:
+			if !ok {
+				e.post(batch)
+				return
+			}
+			if batch = append(batch, ev); len(batch) >= eventBatch {
+				e.post(batch)
+				batch = nil
+			}
+		case <-tick.C:
+			e.post(batch)
+			batch = nil
+		}
+	}
+}
+
+// one json array of events per post; failures are logged, not retried
+func (e *eventSink) post(batch []commitEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		warnf("fn=post err=%v\n", err)
+		return
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		warnf("fn=post err=%v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prism/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		warnf("fn=post events=%v err=%v\n", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		warnf("fn=post events=%v status=%v\n", len(batch), resp.StatusCode)
+		return
+	}
+	debugf("fn=post events=%v\n", len(batch))
+}
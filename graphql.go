@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const graphqlUrl = "https://api.github.com/graphql"
+
+// most commits looked up in one query
+const graphqlCommits = 100
+
+// graphql's rate limit is its own bucket, in points rather than requests,
+// so it's tracked apart from the rest api's
+type graphqlBudget struct {
+	sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+var graphqlRate graphqlBudget
+
+func (b *graphqlBudget) observe(hdr http.Header) {
+	remaining, err := strconv.Atoi(hdr.Get("X-Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(hdr.Get("X-Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.remaining, b.reset = remaining, time.Unix(reset, 0)
+	debugf("fn=graphqlRate remaining=%v\n", remaining)
+}
+
+// sleep out the reset when down to --rate-reserve points
+func (b *graphqlBudget) wait(cfg *Config) {
+	b.Lock()
+	remaining, reset := b.remaining, b.reset
+	b.Unlock()
+
+	if reset.IsZero() || remaining > cfg.RateReserve || time.Now().After(reset) {
+		return
+	}
+
+	rateLimitPauses.Inc()
+	atomic.AddInt64(&stats.pauses, 1)
+	infof("fn=graphqlRate reset=%v wait=%v\n", reset.Format(iso8601), time.Until(reset))
+	time.Sleep(resetWait(cfg, reset))
+}
+
+// post query, decoding its data into v; errors beside data are only logged,
+// as one missing object shouldn't lose the rest
+func graphql(ctx context.Context, cfg *Config, query string, vars map[string]interface{}, v interface{}) error {
+	graphqlRate.wait(cfg)
+
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+	_, auth := cfg.Tokens.current()
+	req, err := http.NewRequestWithContext(ctx, "POST", graphqlUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", "prism/"+version)
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := limiter.Wait(ctx); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	apiRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	apiEndpointRequests.WithLabelValues("graphql").Inc()
+	atomic.AddInt64(&stats.requests, 1)
+
+	if resp.StatusCode == 401 {
+		authFailed(graphqlUrl)
+	}
+	graphqlRate.observe(resp.Header)
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("graphql status %d: %q", resp.StatusCode, b)
+	}
+
+	var result struct {
+		Data   json.RawMessage
+		Errors []struct {
+			Message string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Data) == 0 || string(result.Data) == "null" {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("graphql: %s", result.Errors[0].Message)
+		}
+		return fmt.Errorf("graphql: no data")
+	}
+	for _, e := range result.Errors {
+		warnf("fn=graphql err=%q\n", e.Message)
+	}
+
+	return json.Unmarshal(result.Data, v)
+}
+
+// split a batch into per repo chunks of at most graphqlCommits
+func commitChunks(batch []pendingCommit) (chunks [][]pendingCommit) {
+	var repos []string
+	byRepo := make(map[string][]pendingCommit)
+	for _, p := range batch {
+		if _, ok := byRepo[p.Repo]; !ok {
+			repos = append(repos, p.Repo)
+		}
+		byRepo[p.Repo] = append(byRepo[p.Repo], p)
+	}
+
+	for _, repo := range repos {
+		ps := byRepo[repo]
+		for len(ps) > graphqlCommits {
+			chunks = append(chunks, ps[:graphqlCommits])
+			ps = ps[graphqlCommits:]
+		}
+		chunks = append(chunks, ps)
+	}
+
+	return
+}
+
+// commit fields in a graphql query, matching what commitHandler stores
+// but for files, which graphql doesn't have
+const graphqlCommitFields = `... on Commit {
+	additions
+	deletions
+	message
+	author { name email date user { login } }
+	committer { email date }
+	signature { isValid state }
+	parents(first: 10) { nodes { oid } }
+}`
+
+type graphqlCommit struct {
+	Additions int
+	Deletions int
+	Message   string
+	Author    struct {
+		Name  string
+		Email string
+		Date  string
+		User  *struct {
+			Login string
+		}
+	}
+	Committer struct {
+		Email string
+		Date  string
+	}
+	Signature *struct {
+		IsValid bool
+		State   string
+	}
+	Parents struct {
+		Nodes []struct {
+			Oid string
+		}
+	}
+}
+
+// look up a chunk of one repo's shas in one query, each aliased by its
+// place in the chunk; a sha that comes back null is gone
+func graphqlCommitChunk(ctx context.Context, cfg *Config, st Store, org string, chunk []pendingCommit) {
+	repo := chunk[0].Repo
+	ctx, span := startTask(ctx, cfg, "graphqlCommits", org, repo)
+	defer span.End()
+
+	var q strings.Builder
+	q.WriteString("query($owner: String!, $name: String!) { repository(owner: $owner, name: $name) {\n")
+	for i, p := range chunk {
+		fmt.Fprintf(&q, "c%d: object(oid: %q) { %s }\n", i, p.Sha, graphqlCommitFields)
+	}
+	q.WriteString("} }")
+
+	var data struct {
+		Repository map[string]*graphqlCommit
+	}
+	if err := graphql(ctx, cfg, q.String(), map[string]interface{}{"owner": org, "name": repo}, &data); err != nil {
+		warnf("fn=graphqlCommitChunk org=%v repo=%v commits=%v err=%v\n", org, repo, len(chunk), err)
+		return
+	}
+	if data.Repository == nil {
+		warnf("fn=graphqlCommitChunk org=%v repo=%v at=no-repo\n", org, repo)
+		return
+	}
+
+	for i, p := range chunk {
+		c := data.Repository[fmt.Sprintf("c%d", i)]
+		if c == nil {
+			infof("fn=graphqlCommitChunk org=%v repo=%v sha=%v id=%v at=missing\n", org, repo, p.Sha, p.Id)
+			if err := st.MissingCommit(ctx, p.Id); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		saveGraphqlCommit(ctx, cfg, st, org, p, c)
+	}
+}
+
+func saveGraphqlCommit(ctx context.Context, cfg *Config, st Store, org string, p pendingCommit, c *graphqlCommit) {
+	debugf("fn=saveGraphqlCommit org=%v repo=%v sha=%v id=%v\n", org, p.Repo, p.Sha, p.Id)
+
+	var login *string
+	if c.Author.User != nil {
+		login = &c.Author.User.Login
+	}
+
+	// rest's verification reasons are graphql's signature states, lowercased
+	verified, reason := false, "unsigned"
+	if c.Signature != nil {
+		verified, reason = c.Signature.IsValid, strings.ToLower(c.Signature.State)
+	}
+
+	if cfg.Parents {
+		parents := make([]string, len(c.Parents.Nodes))
+		for i, n := range c.Parents.Nodes {
+			parents[i] = n.Oid
+		}
+		if err := st.CreateCommitParents(ctx, p.Id, parents); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := st.UpdateCommit(ctx, p.Id, commitMeta{
+		Email:              anonymize(cfg, c.Author.Email),
+		Date:               c.Author.Date,
+		Message:            c.Message,
+		Additions:          c.Additions,
+		Deletions:          c.Deletions,
+		Total:              c.Additions + c.Deletions,
+		Name:               c.Author.Name,
+		Login:              login,
+		CommitterEmail:     anonymize(cfg, c.Committer.Email),
+		CommitterDate:      c.Committer.Date,
+		Verified:           verified,
+		VerificationReason: reason,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	commitsUpdated.Inc()
+	atomic.AddInt64(&stats.commitsUpdated, 1)
+}
+
+// one page of an owner's repos; repositoryOwner covers orgs and users alike
+const graphqlReposQuery = `query($owner: String!, $first: Int!, $after: String) {
+	repositoryOwner(login: $owner) {
+		repositories(first: $first, after: $after, orderBy: {field: NAME, direction: ASC}) {
+			pageInfo { hasNextPage endCursor }
+			nodes {
+				name
+				pushedAt
+				isArchived
+				isFork
+				primaryLanguage { name }
+				repositoryTopics(first: 20) { nodes { topic { name } } }
+			}
+		}
+	}
+}`
+
+// list org's repos over graphql, through the same filters as the rest
+// listing; pages go by cursor, so there's no --state-file resume
+func graphqlRepos(ctx context.Context, cfg *Config, st Store, c chan<- func(), org string) {
+	var after *string
+	for pages := 0; pages < cfg.MaxPages; pages++ {
+		var data struct {
+			RepositoryOwner *struct {
+				Repositories struct {
+					PageInfo struct {
+						HasNextPage bool
+						EndCursor   string
+					}
+					Nodes []struct {
+						Name            string
+						PushedAt        *string
+						IsArchived      bool
+						IsFork          bool
+						PrimaryLanguage *struct {
+							Name string
+						}
+						RepositoryTopics struct {
+							Nodes []struct {
+								Topic struct {
+									Name string
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		vars := map[string]interface{}{"owner": org, "first": cfg.PageSize, "after": after}
+		if err := graphql(ctx, cfg, graphqlReposQuery, vars, &data); err != nil {
+			warnf("fn=graphqlRepos org=%v err=%v\n", org, err)
+			return
+		}
+		if data.RepositoryOwner == nil {
+			warnf("fn=graphqlRepos org=%v at=no-owner\n", org)
+			return
+		}
+
+		repos := data.RepositoryOwner.Repositories
+		result := make([]listedRepo, len(repos.Nodes))
+		for i, n := range repos.Nodes {
+			r := listedRepo{Name: n.Name, Archived: n.IsArchived, Fork: n.IsFork}
+			if n.PushedAt != nil {
+				r.Pushed_at = *n.PushedAt
+			}
+			if n.PrimaryLanguage != nil {
+				r.Language = &n.PrimaryLanguage.Name
+			}
+			for _, t := range n.RepositoryTopics.Nodes {
+				r.Topics = append(r.Topics, t.Topic.Name)
+			}
+			result[i] = r
+		}
+		debugf("fn=graphqlRepos org=%v repos=%v\n", org, len(result))
+		listed(ctx, cfg, st, c, org, result)
+
+		if !repos.PageInfo.HasNextPage {
+			return
+		}
+		after = &repos.PageInfo.EndCursor
+	}
+	warnf("fn=graphqlRepos org=%v max_pages=%v at=truncated\n", org, cfg.MaxPages)
+}
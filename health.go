@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// workers ever started and still running, and when one last finished
+	// a task, in unix nanos
+	startedWorkers int64
+	liveWorkers    int64
+	lastProgress   = time.Now().UnixNano()
+)
+
+// note a finished task, for /healthz
+func progressed() {
+	atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+}
+
+// serve /healthz on --health-addr, if set: 503 once the database stops
+// answering, the workers of a --loop or --webhook-addr run are gone, or
+// with --loop, no task has finished in --health-stale
+func serveHealth(cfg *Config, st Store) {
+	if cfg.HealthAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := healthy(r.Context(), cfg, st); err != nil {
+			warnf("fn=healthz err=%v\n", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	go func() {
+		infof("fn=serveHealth addr=%v\n", cfg.HealthAddr)
+		log.Fatal(http.ListenAndServe(cfg.HealthAddr, mux))
+	}()
+}
+
+func healthy(ctx context.Context, cfg *Config, st Store) error {
+	// workers only run for good with a loop or webhooks; otherwise they're
+	// gone once the run's done, say while --api-addr keeps serving. before
+	// the first starts, say during --migrate, is fine too
+	forever := cfg.Loop || cfg.WebhookAddr != ""
+	if forever && atomic.LoadInt64(&startedWorkers) > 0 && atomic.LoadInt64(&liveWorkers) == 0 {
+		return fmt.Errorf("no workers running")
+	}
+
+	if s, ok := st.(*sqlStore); ok {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := s.db.PingContext(ctx); err != nil {
+			return fmt.Errorf("database: %v", err)
+		}
+	}
+
+	if since := time.Since(time.Unix(0, atomic.LoadInt64(&lastProgress))); cfg.Loop && since > cfg.HealthStale {
+		return fmt.Errorf("no task finished in %v", since.Round(time.Second))
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a one-off run's workers exit once it's done, which is no reason for
+// --api-addr's process to look unhealthy; a loop's never should
+func TestHealthyWorkersGone(t *testing.T) {
+	started, live := atomic.LoadInt64(&startedWorkers), atomic.LoadInt64(&liveWorkers)
+	atomic.StoreInt64(&startedWorkers, 4)
+	atomic.StoreInt64(&liveWorkers, 0)
+	t.Cleanup(func() {
+		atomic.StoreInt64(&startedWorkers, started)
+		atomic.StoreInt64(&liveWorkers, live)
+	})
+
+	ctx := context.Background()
+	if err := healthy(ctx, &Config{}, newMemStore()); err != nil {
+		t.Errorf("healthy=%v after a finished run, want nil", err)
+	}
+	if err := healthy(ctx, &Config{Loop: true, HealthStale: time.Hour}, newMemStore()); err == nil {
+		t.Error("healthy=nil with --loop and no workers, want an error")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// commit as written by --output json
+type jsonCommit struct {
+	Type string `json:"type"`
+	Org  string `json:"org"`
+	Repo string `json:"repo"`
+	Sha  string `json:"sha"`
+	commitMeta
+	Files   []commitFile `json:"files"`
+	Parents []string     `json:"parents,omitempty"`
+}
+
+// pull as written by --output json
+type jsonPull struct {
+	Type   string `json:"type"`
+	Org    string `json:"org"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	pullMeta
+}
+
+// Store writing one json object per line instead of to a database; listed
+// shas and pulls are held in memory until the updater fills them in
+type jsonStore struct {
+	sync.Mutex
+	enc     *json.Encoder
+	seen    map[string]bool
+	commits map[string]*jsonCommit
+	pulls   map[string]*jsonPull
+	pendC   map[string][]pendingCommit
+	pendP   map[string][]pendingPull
+}
+
+func newJsonStore(w io.Writer) *jsonStore {
+	return &jsonStore{
+		enc:     json.NewEncoder(w),
+		seen:    make(map[string]bool),
+		commits: make(map[string]*jsonCommit),
+		pulls:   make(map[string]*jsonPull),
+		pendC:   make(map[string][]pendingCommit),
+		pendP:   make(map[string][]pendingPull),
+	}
+}
+
+// one object per line; Encoder appends the newline
+func (s *jsonStore) write(v interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.enc.Encode(v)
+}
+
+// hold sha for the updater, unless already listed this run
+func (s *jsonStore) FindOrCreateCommit(ctx context.Context, org, repo, sha string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	id := fmt.Sprintf("%s/%s/commits/%s", org, repo, sha)
+	if s.seen[id] {
+		return false, nil
+	}
+	s.seen[id] = true
+	s.commits[id] = &jsonCommit{Type: "commit", Org: org, Repo: repo, Sha: sha}
+	s.pendC[org] = append(s.pendC[org], pendingCommit{Id: id, Repo: repo, Sha: sha})
+
+	return true, nil
+}
+
+// write sha out with its metadata and files
+func (s *jsonStore) UpdateCommit(ctx context.Context, id string, m commitMeta) error {
+	s.Lock()
+	c := s.commits[id]
+	delete(s.commits, id)
+	s.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	c.commitMeta = m
+
+	return s.write(c)
+}
+
+// held until UpdateCommit writes the sha
+func (s *jsonStore) CreateCommitFiles(ctx context.Context, id string, files []commitFile) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c := s.commits[id]; c != nil {
+		c.Files = files
+	}
+
+	return nil
+}
+
+// held until UpdateCommit writes the sha
+func (s *jsonStore) CreateCommitParents(ctx context.Context, id string, parents []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c := s.commits[id]; c != nil {
+		c.Parents = parents
+	}
+
+	return nil
+}
+
+// missing shas are dropped, there's nothing to write
+func (s *jsonStore) MissingCommit(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.commits, id)
+
+	return nil
+}
+
+func (s *jsonStore) ResetMissingCommits(ctx context.Context, org string) (int64, error) {
+	return 0, nil
+}
+
+// nothing is kept between runs to dead letter
+func (s *jsonStore) FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (bool, error) {
+	return false, nil
+}
+
+func (s *jsonStore) RetryDeadLetters(ctx context.Context, org string) (int64, error) {
+	return 0, nil
+}
+
+// the state file is the only cursor with --output json
+func (s *jsonStore) SaveProgress(ctx context.Context, orgs []string, p state) error {
+	return nil
+}
+
+func (s *jsonStore) LoadProgress(ctx context.Context, orgs []string) (state, error) {
+	return state{}, nil
+}
+
+// backfills start over with --output json
+func (s *jsonStore) SaveBackfill(ctx context.Context, org, repo, since, until, done string) error {
+	return nil
+}
+
+func (s *jsonStore) LoadBackfill(ctx context.Context, org, repo, since, until string) (string, error) {
+	return "", nil
+}
+
+func (s *jsonStore) SaveEtag(ctx context.Context, url, etag string) error {
+	return nil
+}
+
+func (s *jsonStore) LoadEtag(ctx context.Context, url string) (string, error) {
+	return "", nil
+}
+
+// hand out org's held shas, each only once
+func (s *jsonStore) QueryPendingCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	n := len(s.pendC[org])
+	if n > limit {
+		n = limit
+	}
+	pending := s.pendC[org][:n:n]
+	s.pendC[org] = s.pendC[org][n:]
+
+	return pending, nil
+}
+
+// shas are written out once updated, so there's none to check later
+func (s *jsonStore) QueryUncheckedCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	return nil, nil
+}
+
+func (s *jsonStore) SaveCheckRuns(ctx context.Context, id string, runs []checkRun) error {
+	return s.write(struct {
+		Type      string     `json:"type"`
+		Id        string     `json:"id"`
+		CheckRuns []checkRun `json:"check_runs"`
+	}{"check_runs", id, runs})
+}
+
+// nothing kept between runs
+func (s *jsonStore) LatestCommitDate(ctx context.Context, org, repo string) (string, error) {
+	return "", nil
+}
+
+// hold pull for the updater, unless already listed this run
+func (s *jsonStore) FindOrCreatePull(ctx context.Context, org, repo string, number int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	id := fmt.Sprintf("%s/%s/pulls/%d", org, repo, number)
+	if s.seen[id] {
+		return false, nil
+	}
+	s.seen[id] = true
+	s.pulls[id] = &jsonPull{Type: "pull", Org: org, Repo: repo, Number: number}
+	s.pendP[org] = append(s.pendP[org], pendingPull{Id: id, Repo: repo, Number: number})
+
+	return true, nil
+}
+
+// only pulls still held can be written out
+func (s *jsonStore) FindPull(ctx context.Context, org, repo string, number int) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	id := fmt.Sprintf("%s/%s/pulls/%d", org, repo, number)
+	if s.pulls[id] == nil {
+		return "", nil
+	}
+
+	return id, nil
+}
+
+// write pull out with its metadata
+func (s *jsonStore) UpdatePull(ctx context.Context, id string, m pullMeta) error {
+	s.Lock()
+	p := s.pulls[id]
+	delete(s.pulls, id)
+	s.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	p.pullMeta = m
+
+	return s.write(p)
+}
+
+// hand out org's held pulls, each only once
+func (s *jsonStore) QueryPendingPulls(ctx context.Context, org, after string, limit int) ([]pendingPull, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	n := len(s.pendP[org])
+	if n > limit {
+		n = limit
+	}
+	pending := s.pendP[org][:n:n]
+	s.pendP[org] = s.pendP[org][n:]
+
+	return pending, nil
+}
+
+func (s *jsonStore) SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) error {
+	return s.write(struct {
+		Type string `json:"type"`
+		Org  string `json:"org"`
+		Repo string `json:"repo"`
+		dependabotAlert
+	}{"dependabot_alert", org, repo, a})
+}
+
+func (s *jsonStore) SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) error {
+	return s.write(struct {
+		Type string `json:"type"`
+		Org  string `json:"org"`
+		Repo string `json:"repo"`
+		codeScanningAlert
+	}{"code_scanning_alert", org, repo, a})
+}
+
+func (s *jsonStore) SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) error {
+	return s.write(struct {
+		Type string `json:"type"`
+		Org  string `json:"org"`
+		Repo string `json:"repo"`
+		workflowRun
+	}{"workflow_run", org, repo, r})
+}
+
+func (s *jsonStore) SaveDeployment(ctx context.Context, org, repo string, d deployment) error {
+	return s.write(struct {
+		Type string `json:"type"`
+		Org  string `json:"org"`
+		Repo string `json:"repo"`
+		deployment
+	}{"deployment", org, repo, d})
+}
+
+func (s *jsonStore) SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, st deploymentStatus) error {
+	return s.write(struct {
+		Type         string `json:"type"`
+		Org          string `json:"org"`
+		Repo         string `json:"repo"`
+		DeploymentId int64  `json:"deployment_id"`
+		deploymentStatus
+	}{"deployment_status", org, repo, deploymentId, st})
+}
+
+func (s *jsonStore) SaveTeam(ctx context.Context, org, slug, name string) error {
+	return s.write(struct {
+		Type string `json:"type"`
+		Org  string `json:"org"`
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}{"team", org, slug, name})
+}
+
+func (s *jsonStore) SaveTeamMembers(ctx context.Context, org, slug string, logins []string) error {
+	return s.write(struct {
+		Type    string   `json:"type"`
+		Org     string   `json:"org"`
+		Slug    string   `json:"slug"`
+		Members []string `json:"members"`
+	}{"team_members", org, slug, logins})
+}
+
+func (s *jsonStore) SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) error {
+	return s.write(struct {
+		Type      string         `json:"type"`
+		Org       string         `json:"org"`
+		Repo      string         `json:"repo"`
+		Languages map[string]int `json:"languages"`
+	}{"repo_languages", org, repo, langs})
+}
+
+func (s *jsonStore) SaveRepoTopics(ctx context.Context, org, repo string, topics []string) error {
+	return s.write(struct {
+		Type   string   `json:"type"`
+		Org    string   `json:"org"`
+		Repo   string   `json:"repo"`
+		Topics []string `json:"topics"`
+	}{"repo_topics", org, repo, topics})
+}
+
+// nothing kept to delete
+func (s *jsonStore) QueryRepos(ctx context.Context, org string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *jsonStore) DeleteRepo(ctx context.Context, org, repo string) (int64, error) {
+	return 0, nil
+}
+
+// no schema to migrate
+func (s *jsonStore) Migrate(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// with --orgs a,b each org's updater must only get its own held rows, or
+// shas are looked up under the wrong org, 404 and get dropped
+func TestJsonPendingPerOrg(t *testing.T) {
+	ctx := context.Background()
+	s := newJsonStore(io.Discard)
+	for _, org := range []string{"a", "b"} {
+		if _, err := s.FindOrCreateCommit(ctx, org, "r", "sha-"+org); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.FindOrCreatePull(ctx, org, "r", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, org := range []string{"b", "a"} {
+		commits, err := s.QueryPendingCommits(ctx, org, "", 10)
+		if err != nil || len(commits) != 1 || commits[0].Sha != "sha-"+org {
+			t.Errorf("org %s: commits=%v err=%v, want just sha-%s", org, commits, err, org)
+		}
+		pulls, err := s.QueryPendingPulls(ctx, org, "", 10)
+		if err != nil || len(pulls) != 1 || pulls[0].Id != org+"/r/pulls/1" {
+			t.Errorf("org %s: pulls=%v err=%v, want just %s/r/pulls/1", org, pulls, err, org)
+		}
+	}
+
+	// each handed out only once
+	if commits, _ := s.QueryPendingCommits(ctx, "a", "", 10); len(commits) != 0 {
+		t.Errorf("commits=%v, want none left", commits)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var (
+	levelNames = []string{"debug", "info", "warn", "error"}
+	minLevel   = levelInfo
+)
+
+func parseLevel(name string) int {
+	for level, n := range levelNames {
+		if n == name {
+			return level
+		}
+	}
+
+	log.Fatalf("unknown log level %q", name)
+	return levelInfo
+}
+
+// log with a level=... field, dropping anything below --log-level
+func logf(level int, format string, v ...interface{}) {
+	if level < minLevel {
+		return
+	}
+
+	log.Output(3, "level="+levelNames[level]+" "+fmt.Sprintf(format, v...))
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+func infof(format string, v ...interface{})  { logf(levelInfo, format, v...) }
+func warnf(format string, v ...interface{})  { logf(levelWarn, format, v...) }
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
+
+// rewrites each "key=val" log line as a json object
+type jsonWriter struct {
+	w io.Writer
+}
+
+func (j jsonWriter) Write(p []byte) (int, error) {
+	fields := logfmt(strings.TrimSpace(string(p)))
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// parse key=val and key="quoted val" pairs; the file:line from
+// Lshortfile goes to file, any other bare words to msg
+func logfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	var msg []string
+
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		word := line
+		if i := strings.IndexAny(line, " ="); i >= 0 {
+			word = line[:i]
+		}
+		line = line[len(word):]
+
+		if !strings.HasPrefix(line, "=") {
+			if strings.HasSuffix(word, ":") && strings.Contains(word, ".go:") {
+				fields["file"] = strings.TrimSuffix(word, ":")
+			} else {
+				msg = append(msg, word)
+			}
+			continue
+		}
+		line = line[1:]
+
+		val := line
+		if quoted, err := strconv.QuotedPrefix(line); err == nil {
+			val, _ = strconv.Unquote(quoted)
+			line = line[len(quoted):]
+		} else {
+			if i := strings.IndexByte(line, ' '); i >= 0 {
+				val = line[:i]
+			}
+			line = line[len(val):]
+		}
+		fields[word] = val
+	}
+
+	if len(msg) > 0 {
+		fields["msg"] = strings.Join(msg, " ")
+	}
+
+	return fields
+}
@@ -2,54 +2,142 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"flag"
 	"fmt"
-	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	inserter = flag.Bool("inserter", false, "Insert Worker")
-	updater  = flag.Bool("updater", false, "Update Worker")
-	loop     = flag.Bool("loop", false, "Loop Worker")
-	limit    = flag.Int("limit", 1000, "Query Limit")
-	scale    = flag.Int("scale", 5, "Number of Workers")
-	delay    = flag.Int("delay", 15, "Delay")
-	since    = flag.String("since", "", "Since Timestamp")
-	until    = flag.String("until", "", "Until Timestamp")
-	org      = mustGetenv("ORG")
-	ignores  = makeIgnored(os.Getenv("IGNORE_REPOS"))
-	auth     = "token " + mustGetenv("OAUTH_TOKEN")
-	db       = dbOpen(mustGetenv("DATABASE_URL"))
-	urlRe    = regexp.MustCompile("<(.*)>; rel=\"(.*)\"")
-	iso8601  = "2006-01-02T15:04:05Z"
-	next     = time.Now().Format(iso8601)
-	now      string
-	wg       sync.WaitGroup
-	pg       sync.WaitGroup
+	limiter = rate.NewLimiter(rate.Inf, 1)
+	urlRe   = regexp.MustCompile("<(.*?)>; rel=\"(.*?)\"")
+	repoRe  = regexp.MustCompile(`^([A-Za-z0-9_.-]+/)?[A-Za-z0-9_.-]+$`)
+	iso8601 = "2006-01-02T15:04:05Z"
+	next    = time.Now().Format(iso8601)
+	repoSem chan struct{}
+	now     string
+	resume  state
+	wg      sync.WaitGroup
+	pg      sync.WaitGroup
 )
 
+// sent as User-Agent: prism/<version>
+const version = "0.1.0"
+
+// exit code when the token is rejected, distinct from log.Fatal's 1
+const exitAuth = 3
+
 type handler func(io.Reader)
 
+// Doer sends api requests; *http.Client is one, and tests can swap in a stub
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// every github request goes through client
+var client Doer = http.DefaultClient
+
+// backing off a secondary rate limit sleeps here, so tests can see how long
+var sleep = time.Sleep
+
+// logs each response's time to headers and bytes read, once its body is
+// closed; only wrapped around client at --log-level debug
+type timedDoer struct {
+	Doer
+}
+
+func (d timedDoer) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := d.Doer.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, url: req.URL.String(), status: resp.StatusCode, elapsed: time.Since(start)}
+
+	return resp, nil
+}
+
+type timedBody struct {
+	io.ReadCloser
+	url     string
+	status  int
+	elapsed time.Duration
+	bytes   int64
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	debugf("fn=request url=%q status=%v ms=%v bytes=%v\n", b.url, b.status, b.elapsed.Milliseconds(), b.bytes)
+
+	return b.ReadCloser.Close()
+}
+
+// client going through --proxy, else whatever HTTPS_PROXY and NO_PROXY say;
+// one transport for every request, keeping enough idle connections that
+// --scale workers on api.github.com reuse them rather than handshaking anew
+func newClient(cfg *Config) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			log.Fatalf("bad proxy %q: %v", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = poolSize(cfg) // one per worker, all talking to github
+	t.IdleConnTimeout = 90 * time.Second
+
+	return &http.Client{Transport: t}
+}
+
+// Retry-After's seconds, or def when it's missing or an http date
+func retryAfter(hdr http.Header, def time.Duration) time.Duration {
+	n, err := strconv.Atoi(hdr.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return def
+	}
+
+	return time.Duration(n) * time.Second
+}
+
 // get the next url from the link headers
 // http://developer.github.com/v3/#pagination
 func nextUrl(hdr http.Header) string {
 	for _, link := range hdr["Link"] {
-		urls := strings.Split(link, ",")
-		for _, url := range urls {
-			ms := urlRe.FindStringSubmatch(url)
-			if len(ms) == 3 && ms[2] == "next" {
+		// match each <url>; rel="..." rather than splitting on commas,
+		// which urls can contain
+		for _, ms := range urlRe.FindAllStringSubmatch(link, -1) {
+			if ms[2] == "next" {
 				return ms[1]
 			}
 		}
@@ -60,7 +148,7 @@ func nextUrl(hdr http.Header) string {
 
 // check rate limiting headers
 // http://developer.github.com/v3/#rate-limiting
-func rateLimit(hdr http.Header) bool {
+func rateLimit(cfg *Config, hdr http.Header, token int) bool {
 	remaining, err := strconv.Atoi(hdr["X-Ratelimit-Remaining"][0])
 	if err != nil {
 		log.Fatal(err)
@@ -71,214 +159,569 @@ func rateLimit(hdr http.Header) bool {
 		log.Fatal(err)
 	}
 
-	log.Printf("fn=rateLimit remaining=%v\n", remaining)
-	if remaining == 0 {
+	debugf("fn=rateLimit token=%v remaining=%v\n", token, remaining)
+	cfg.Tokens.observe(token, remaining, time.Unix(int64(reset), 0))
+	rateLimitRemaining.Set(float64(remaining))
+	atomic.StoreInt64(&stats.remaining, int64(remaining))
+	budget.observe(remaining, hdr.Get("X-Ratelimit-Limit"), time.Unix(int64(reset), 0))
+	pace(cfg, remaining-cfg.RateReserve, time.Unix(int64(reset), 0))
+	if remaining <= cfg.RateReserve {
+		rateLimitPauses.Inc()
+		atomic.AddInt64(&stats.pauses, 1)
 		resetAt := time.Unix(int64(reset), 0)
-		log.Printf("fn=rateLimit reset=%v wait=%v\n", resetAt.Format(iso8601), resetAt.Sub(time.Now()))
-		// use delay... don't sleep for wait, as remaining can stay 0 during reset update :(
-		time.Sleep(time.Duration(*delay) * time.Second)
+		infof("fn=rateLimit reset=%v wait=%v\n", resetAt.Format(iso8601), resetAt.Sub(time.Now()))
+		if cfg.Tokens.rotate(token, cfg.RateReserve) {
+			infof("fn=rateLimit at=rotate token=%v\n", cfg.Tokens.index())
+			return true
+		}
+
+		// leave the reserve to other consumers of the token until reset,
+		// or until whichever token resets first
+		if remaining > 0 {
+			infof("fn=rateLimit at=reserve remaining=%v reserve=%v\n", remaining, cfg.RateReserve)
+		}
+		time.Sleep(resetWait(cfg, cfg.Tokens.soonest()))
 		return true
 	}
 
 	return false
 }
 
+// sleep until reset plus a buffer, capped so a bad clock can't stall us; never
+// less than delay, as remaining can stay 0 during reset update :(
+func resetWait(cfg *Config, resetAt time.Time) time.Duration {
+	wait := resetAt.Sub(time.Now()) + 5*time.Second
+	if wait > time.Hour {
+		wait = time.Hour
+	}
+	if min := time.Duration(cfg.Delay) * time.Second; wait < min {
+		wait = min
+	}
+
+	return wait
+}
+
+// last observed rate limit, for sizing the sleep between loops
+type rateBudget struct {
+	sync.Mutex
+	remaining int
+	limit     int
+	reset     time.Time
+}
+
+var budget rateBudget
+
+func (b *rateBudget) observe(remaining int, limit string, reset time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.remaining, b.reset = remaining, reset
+	if n, err := strconv.Atoi(limit); err == nil {
+		b.limit = n
+	}
+}
+
+// sleep between loops: --delay, or with --max-delay, somewhere between
+// --min-delay and --max-delay, longer the more of the budget is spent;
+// never past reset when the budget is down to the reserve
+func loopDelay(cfg *Config) time.Duration {
+	fixed := time.Duration(cfg.Delay) * time.Second
+	if cfg.MaxDelay <= 0 {
+		return fixed
+	}
+
+	budget.Lock()
+	remaining, limit, reset := budget.remaining, budget.limit, budget.reset
+	budget.Unlock()
+
+	wait := cfg.MinDelay
+	switch {
+	case limit <= 0:
+		// nothing observed yet
+	case remaining <= cfg.RateReserve:
+		wait = reset.Sub(time.Now())
+	default:
+		spent := 1 - float64(remaining)/float64(limit)
+		wait += time.Duration(spent * float64(cfg.MaxDelay-cfg.MinDelay))
+	}
+	if wait < cfg.MinDelay {
+		wait = cfg.MinDelay
+	}
+	if wait > cfg.MaxDelay {
+		wait = cfg.MaxDelay
+	}
+	debugf("fn=loopDelay remaining=%v limit=%v wait=%v\n", remaining, limit, wait)
+
+	return wait
+}
+
+// spread the remaining budget evenly until reset, unless --rate is fixed
+func pace(cfg *Config, remaining int, resetAt time.Time) {
+	if cfg.Rate > 0 {
+		limiter.SetLimit(rate.Limit(cfg.Rate))
+		return
+	}
+
+	if wait := resetAt.Sub(time.Now()).Seconds(); wait > 0 && remaining > 0 {
+		limiter.SetLimit(rate.Limit(float64(remaining) / wait))
+	}
+}
+
 // check rate limit
-func rateLimitCheck() bool {
-	req, err := http.NewRequest("GET", "https://api.github.com/rate_limit", nil)
+func rateLimitCheck(ctx context.Context, cfg *Config) bool {
+	token, auth := cfg.Tokens.current()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/rate_limit", nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", "prism/"+version)
+	req.Header.Set("Accept", cfg.Accept)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
+		// cancelled... let request notice and stop
+		if ctx.Err() != nil {
+			return false
+		}
 		log.Fatal(err)
 	}
 	defer resp.Body.Close()
 
-	return rateLimit(resp.Header)
+	if resp.StatusCode == 401 {
+		authFailed(req.URL.String())
+	}
+
+	return rateLimit(cfg, resp.Header, token)
 }
 
-// requests for repos, commits, and shas; returned url controls iteration
-func request(url string, h handler, etags map[string]string) string {
-	if rateLimitCheck() {
-		return url
+// token expired or revoked... nothing will succeed, so stop loudly
+func authFailed(url string) {
+	errorf("fn=authFailed url=%q at=error msg=\"AUTHENTICATION FAILED: check OAUTH_TOKEN, OAUTH_TOKEN_FILE, or GITHUB_APP_*\"\n", url)
+	slack.post("prism failed: authentication failed for " + url)
+	os.Exit(exitAuth)
+}
+
+// requests for repos, commits, and shas; returned url controls iteration,
+// returned status is the last response's status code
+func request(ctx context.Context, cfg *Config, url string, h handler, etags Store) (string, int) {
+	if !breakers.allow(url) {
+		debugf("fn=request url=%q at=breaker-open\n", url)
+		return "", 0
+	}
+
+	// past --task-timeout: stop, leaving the rest for the next loop
+	if deadline, ok := taskDeadline(ctx); ok {
+		if time.Now().After(deadline) {
+			debugf("fn=request url=%q at=task-timeout\n", url)
+			return "", 0
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if rateLimitCheck(ctx, cfg) {
+		return url, 0
 	}
 
-	log.Printf("fn=request url=%q\n", url)
-	req, err := http.NewRequest("GET", url, nil)
+	org, repo := urlRepo(url)
+	ctx, span := tracer.Start(ctx, "request", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("url", url), attribute.String("org", org), attribute.String("repo", repo)))
+	defer span.End()
+
+	debugf("fn=request url=%q\n", url)
+	token, auth := cfg.Tokens.current()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", "prism/"+version)
+	req.Header.Set("Accept", cfg.Accept)
+	req.Header.Set("Accept-Encoding", "gzip")
 
 	if etags != nil {
-		if etag := etags[url]; etag != "" {
+		etag, err := etags.LoadEtag(ctx, url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if etag != "" {
 			req.Header.Set("If-None-Match", etag)
 		}
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// shared across workers, so concurrent requests can't outrun the budget
+	if err := limiter.Wait(ctx); err != nil {
+		warnf("fn=request url=%q err=%v\n", url, err)
+		return "", 0
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			warnf("fn=request url=%q err=%v\n", url, err)
+			return "", 0
+		}
 		log.Fatal(err)
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("status", resp.StatusCode))
+	apiRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	apiEndpointRequests.WithLabelValues(endpoint(url)).Inc()
+	atomic.AddInt64(&stats.requests, 1)
+
+	rc, err := responseBody(resp)
+	if err != nil {
+		warnf("fn=request url=%q status=%v err=%v\n", url, resp.StatusCode, err)
+		return nextUrl(resp.Header), resp.StatusCode
+	}
+
+	if resp.StatusCode == 401 {
+		authFailed(url)
+	}
 
 	// yes, check rate limit headers again
-	if rateLimit(resp.Header) {
-		return url
+	if rateLimit(cfg, resp.Header, token) {
+		return url, resp.StatusCode
 	}
 
-	// 409 - empty repository
-	if resp.StatusCode != 200 {
-		if resp.StatusCode != 304 {
-			body, _ := ioutil.ReadAll(resp.Body)
-			log.Printf("url=%v StatusCode=%v Body=%q\n", url, resp.StatusCode, body)
+	// 202 - stats still being computed, body is partial... rather than
+	// handing zeros to the handler, stop and let the caller look again later
+	if resp.StatusCode == 202 {
+		infof("fn=request url=%q status=202 at=computing\n", url)
+		return "", resp.StatusCode
+	}
+
+	// 403 - secondary rate limit... remaining isn't 0, so back off and retry
+	if resp.StatusCode == 403 {
+		body, _ := ioutil.ReadAll(rc)
+		if resp.Header.Get("Retry-After") != "" || bytes.Contains(body, []byte("rate limit")) {
+			wait := retryAfter(resp.Header, time.Duration(cfg.Delay)*time.Second)
+			warnf("fn=request url=%q status=403 at=rate-limited wait=%v\n", url, wait)
+			sleep(wait)
+			return url, resp.StatusCode
 		}
 
-		return nextUrl(resp.Header)
+		// 403 - forbidden, e.g. SSO not authorized, alerts disabled, or missing scope
+		warnf("fn=request url=%q org=%v repo=%v status=403 at=forbidden body=%q\n", url, org, repo, body)
+		breakers.fail(cfg, url)
+		return nextUrl(resp.Header), resp.StatusCode
 	}
 
-	if etags != nil {
-		etags[url] = resp.Header["Etag"][0]
+	// 409 - listing an empty repository's commits, common for new ones;
+	// nothing to list, and nothing wrong with the repo either. elsewhere a
+	// 409 is a conflict worth the warning below
+	if resp.StatusCode == 409 && endpoint(url) == "commits" {
+		debugf("fn=request url=%q org=%v repo=%v status=409 at=empty-repo\n", url, org, repo)
+		return "", resp.StatusCode
 	}
 
-	h(resp.Body)
+	// 404 - missing, e.g. force-pushed sha, deleted repo, or code scanning off
+	if resp.StatusCode != 200 {
+		if resp.StatusCode != 304 {
+			body, _ := ioutil.ReadAll(rc)
+			warnf("url=%v StatusCode=%v Body=%q\n", url, resp.StatusCode, body)
+		}
 
-	return nextUrl(resp.Header)
-}
+		// a missing sha is the commit's problem, not the repo's
+		if resp.StatusCode >= 500 || (resp.StatusCode == 404 && !strings.Contains(url, "/commits/")) {
+			breakers.fail(cfg, url)
+		}
 
-// loop requests based on returned url
-func requests(url string, h handler, etags map[string]string) {
-	for url != "" {
-		url = request(url, h, etags)
+		return nextUrl(resp.Header), resp.StatusCode
 	}
-}
 
-// find shas the need metadata
-func queryCommits(c chan<- func()) {
-	rows, err := db.Query("SELECT id, repo, sha FROM commits WHERE org=$1 AND email IS NULL LIMIT $2", org, *limit)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
+	breakers.ok(url)
 
-	more := false
-	for rows.Next() {
-		var id, repo, sha string
-		if err := rows.Scan(&id, &repo, &sha); err != nil {
+	h(rc)
+
+	// only once the handler's written the row, so a later 304 has one to leave
+	if etag := resp.Header.Get("Etag"); etags != nil && etag != "" {
+		if err := etags.SaveEtag(ctx, url, etag); err != nil {
 			log.Fatal(err)
 		}
+	}
+
+	return nextUrl(resp.Header), resp.StatusCode
+}
 
-		// closure to lookup sha
-		c <- func(id, repo, sha string) func() { return func() { commit(id, repo, sha) } }(id, repo, sha)
-		more = true
+// body of resp, gunzipped by hand as setting Accept-Encoding ourselves stops
+// the transport doing it; bodies it did decompress have no Content-Encoding
+func responseBody(resp *http.Response) (io.Reader, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp.Body, nil
 	}
 
-	if more {
-		// found something... look for more
-		c <- func() { queryCommits(c) }
-	} else {
-		log.Println("fn=query_commits at=done")
+	zr, err := gzip.NewReader(resp.Body)
+	if err == io.EOF {
+		// nothing to decompress, as on a 304
+		return bytes.NewReader(nil), nil
+	}
 
-		// delay before looping, or close worker channel
-		if *loop {
-			time.Sleep(time.Duration(*delay) * time.Second)
-			c <- func() { queryCommits(c) }
-		} else {
-			pg.Done()
+	return zr, err
+}
+
+// etags saved but not sent, for looking up in full a row that a 304 left
+// pending, e.g. when the handler couldn't decode the earlier response
+type fullLookup struct {
+	Store
+}
+
+func (s fullLookup) LoadEtag(ctx context.Context, url string) (string, error) {
+	return "", nil
+}
+
+// consecutive failures per repo and endpoint, e.g. x/y/pulls, tripping
+// after --breaker-failures so a broken repo is left alone for
+// --breaker-cooldown rather than retried every loop
+type breakerSet struct {
+	sync.Mutex
+	failures map[string]int
+	until    map[string]time.Time
+}
+
+var breakers = breakerSet{failures: make(map[string]int), until: make(map[string]time.Time)}
+
+// org/repo/endpoint of an api url, empty for urls outside a repo
+func breakerKey(url string) string {
+	parts := strings.Split(strings.SplitN(url, "?", 2)[0], "/")
+	for i, part := range parts {
+		if part == "repos" && i+3 < len(parts) {
+			return strings.Join(parts[i+1:i+4], "/")
 		}
 	}
+
+	return ""
 }
 
-// check if sha already there, or insert it
-func findOrCreateCommits(repo, sha string) {
-	rows, err := db.Query("SELECT id FROM commits WHERE org=$1 AND repo=$2 AND sha=$3", org, repo, sha)
-	if err != nil {
-		log.Fatal(err)
+func (b *breakerSet) allow(url string) bool {
+	key := breakerKey(url)
+	if key == "" {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	until, ok := b.until[key]
+	if !ok {
+		return true
 	}
-	defer rows.Close()
+	if time.Now().Before(until) {
+		return false
+	}
+
+	infof("fn=breaker key=%v at=close\n", key)
+	delete(b.until, key)
+	delete(b.failures, key)
+
+	return true
+}
 
-	if rows.Next() {
+func (b *breakerSet) fail(cfg *Config, url string) {
+	key := breakerKey(url)
+	if key == "" || cfg.BreakerFailures <= 0 {
 		return
 	}
+	b.Lock()
+	defer b.Unlock()
 
-	if _, err := db.Exec("INSERT INTO commits (org, repo, sha) VALUES ($1, $2, $3)", org, repo, sha); err != nil {
-		log.Fatal(err)
+	if b.failures[key]++; b.failures[key] >= cfg.BreakerFailures {
+		if _, open := b.until[key]; !open {
+			warnf("fn=breaker key=%v failures=%v cooldown=%v at=open\n", key, b.failures[key], cfg.BreakerCooldown)
+		}
+		b.until[key] = time.Now().Add(cfg.BreakerCooldown)
 	}
 }
 
-// add metadata to sha
-func updateCommits(id, email, date, message string, additions, deletions, total int) {
-	if _, err := db.Exec("UPDATE commits SET email=$2, date=$3, msg=$4, adds=$5, dels=$6, total=$7 WHERE id=$1", id, email, date, message, additions, deletions, total); err != nil {
-		log.Fatal(err)
+func (b *breakerSet) ok(url string) {
+	key := breakerKey(url)
+	if key == "" {
+		return
 	}
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.failures, key)
 }
 
-// find pulls that need metadata
-func queryPulls(c chan<- func()) {
-	rows, err := db.Query("SELECT id, repo, number FROM pulls WHERE org=$1 AND title IS NULL LIMIT $2", org, *limit)
-	if err != nil {
-		log.Fatal(err)
+// org and repo name from an api url, if it has them
+func urlRepo(url string) (string, string) {
+	parts := strings.Split(strings.SplitN(url, "?", 2)[0], "/")
+	for i, part := range parts {
+		if part == "repos" && i+2 < len(parts) {
+			return parts[i+1], parts[i+2]
+		}
 	}
-	defer rows.Close()
 
-	more := false
-	for rows.Next() {
-		var id, repo string
-		var number int
-		if err := rows.Scan(&id, &repo, &number); err != nil {
-			log.Fatal(err)
+	return "", ""
+}
+
+// loop requests based on returned url, returning the last status code,
+// or 0 when cut short; retries of the same url don't count against
+// --max-pages
+func requests(ctx context.Context, cfg *Config, url string, h handler, etags Store) (status int) {
+	for pages := 0; url != ""; {
+		if pages >= cfg.MaxPages {
+			warnf("fn=requests url=%q pages=%v at=max-pages\n", url, pages)
+			return 0
 		}
 
-		// closure to lookup number
-		c <- func(id, repo string, number int) func() { return func() { pull(id, repo, number) } }(id, repo, number)
-		more = true
+		var u string
+		if u, status = request(ctx, cfg, url, h, etags); u != url {
+			pages++
+		}
+		url = u
 	}
 
-	if more {
-		// found something... look for more
-		c <- func() { queryPulls(c) }
-	} else {
-		log.Println("fn=query_pulls at=done")
+	return
+}
+
+// find shas the need metadata, a batch at a time, paging forward by id;
+// each batch drains through the workers before the next is fetched
+func queryCommits(ctx context.Context, cfg *Config, st Store, c chan<- func()) {
+	defer pg.Done()
+
+	after, i := "", 0
+	for {
+		org := cfg.Orgs[i]
+		batch, err := st.QueryPendingCommits(ctx, org, after, cfg.Limit)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		// delay before looping, or close worker channel
-		if *loop {
-			time.Sleep(time.Duration(*delay) * time.Second)
-			c <- func() { queryPulls(c) }
+		// closure to lookup sha, or with graphql a chunk of a repo's shas
+		var done sync.WaitGroup
+		if cfg.GraphqlCommits {
+			chunks := commitChunks(batch)
+			done.Add(len(chunks))
+			for _, chunk := range chunks {
+				c <- func(chunk []pendingCommit) func() {
+					return func() { defer done.Done(); graphqlCommitChunk(ctx, cfg, st, org, chunk) }
+				}(chunk)
+			}
 		} else {
-			pg.Done()
+			done.Add(len(batch))
+			for _, p := range batch {
+				c <- lookupCommit(ctx, cfg, st, c, &done, org, p, 1)
+			}
+		}
+		done.Wait()
+
+		// found something... look past it for more, so rows a batch failed
+		// to fill in wait for the next pass rather than repeating now
+		if len(batch) > 0 {
+			after = batch[len(batch)-1].Id
+			continue
+		}
+
+		// on to the next org, then around again from the first
+		if after, i = "", i+1; i < len(cfg.Orgs) {
+			continue
+		}
+		i = 0
+
+		infof("fn=query_commits at=done\n")
+
+		// delay before looping, or finish
+		if !cfg.Loop {
+			return
 		}
+		time.Sleep(loopDelay(cfg))
 	}
 }
 
-// check if pull already there, or insert it
-func findOrCreatePulls(repo string, number int) {
-	rows, err := db.Query("SELECT id FROM pulls WHERE org=$1 AND repo=$2 AND number=$3", org, repo, number)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
+// query for shas without check runs, then add to worker
+func queryCheckRuns(ctx context.Context, cfg *Config, st Store, c chan<- func()) {
+	defer pg.Done()
 
-	if rows.Next() {
-		return
-	}
+	after, i := "", 0
+	for {
+		org := cfg.Orgs[i]
+		batch, err := st.QueryUncheckedCommits(ctx, org, after, cfg.Limit)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	if _, err := db.Exec("INSERT INTO pulls (org, repo, number) VALUES ($1, $2, $3)", org, repo, number); err != nil {
-		log.Fatal(err)
+		// closure to lookup sha's check runs
+		var done sync.WaitGroup
+		done.Add(len(batch))
+		for _, p := range batch {
+			c <- func(p pendingCommit) func() {
+				return func() { defer done.Done(); checkRuns(ctx, cfg, st, org, p.Id, p.Repo, p.Sha) }
+			}(p)
+		}
+		done.Wait()
+
+		if len(batch) > 0 {
+			after = batch[len(batch)-1].Id
+			continue
+		}
+
+		// on to the next org, then around again from the first
+		if after, i = "", i+1; i < len(cfg.Orgs) {
+			continue
+		}
+		i = 0
+
+		infof("fn=query_check_runs at=done\n")
+
+		// delay before looping, or finish
+		if !cfg.Loop {
+			return
+		}
+		time.Sleep(loopDelay(cfg))
 	}
 }
 
-// add metadata to pull
-func updatePulls(id, title string, comments, commits, additions, deletions, changed_files int) {
-	if _, err := db.Exec("UPDATE pulls SET title=$2, comments=$3, commits=$4, adds=$5, dels=$6, changed=$7 WHERE id=$1", id, title, comments, commits, additions, deletions, changed_files); err != nil {
-		log.Fatal(err)
+// find pulls that need metadata, a batch at a time, paging forward by id;
+// each batch drains through the workers before the next is fetched
+func queryPulls(ctx context.Context, cfg *Config, st Store, c chan<- func()) {
+	defer pg.Done()
+
+	after, i := "", 0
+	for {
+		org := cfg.Orgs[i]
+		batch, err := st.QueryPendingPulls(ctx, org, after, cfg.Limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// closure to lookup number
+		var done sync.WaitGroup
+		done.Add(len(batch))
+		for _, p := range batch {
+			c <- func(p pendingPull) func() {
+				return func() { defer done.Done(); pull(ctx, cfg, st, org, p.Id, p.Repo, p.Number, true) }
+			}(p)
+		}
+		done.Wait()
+
+		// found something... look past it for more, so rows a batch failed
+		// to fill in wait for the next pass rather than repeating now
+		if len(batch) > 0 {
+			after = batch[len(batch)-1].Id
+			continue
+		}
+
+		// on to the next org, then around again from the first
+		if after, i = "", i+1; i < len(cfg.Orgs) {
+			continue
+		}
+		i = 0
+
+		infof("fn=query_pulls at=done\n")
+
+		// delay before looping, or finish
+		if !cfg.Loop {
+			return
+		}
+		time.Sleep(loopDelay(cfg))
 	}
 }
 
 // shas request processing
-func pullHandler(id, repo string, number int) handler {
+func pullHandler(ctx context.Context, cfg *Config, st Store, org, id, repo string, number int) handler {
 	return func(rc io.Reader) {
 
 		// http://developer.github.com/v3/pulls/#get-a-single-pull-request
@@ -289,179 +732,1122 @@ func pullHandler(id, repo string, number int) handler {
 			Additions     int
 			Deletions     int
 			Changed_files int
+			State         string
+			Created_at    string
+			Merged_at     *string
+			Closed_at     *string
+			User          struct {
+				Login string
+			}
 		}
 
+		var raw bytes.Buffer
+		if cfg.Raw {
+			rc = io.TeeReader(rc, &raw)
+		}
 		if err := json.NewDecoder(rc).Decode(&result); err != nil {
-			log.Printf("fn=pullHandler err=%v org=%v repo=%v number=%v id=%v\n", err, org, repo, number, id)
+			warnf("fn=pullHandler err=%v org=%v repo=%v number=%v id=%v\n", err, org, repo, number, id)
 			return
 		}
 
-		log.Printf("fn=pullHandler org=%v repo=%v number=%v id=%v\n", org, repo, number, id)
-		updatePulls(id,
-			result.Title,
-			result.Comments,
-			result.Commits,
-			result.Additions,
-			result.Deletions,
-			result.Changed_files)
+		debugf("fn=pullHandler org=%v repo=%v number=%v id=%v\n", org, repo, number, id)
+		if err := st.UpdatePull(ctx, id, pullMeta{
+			Title:     result.Title,
+			Comments:  result.Comments,
+			Commits:   result.Commits,
+			Additions: result.Additions,
+			Deletions: result.Deletions,
+			Changed:   result.Changed_files,
+			State:     result.State,
+			Login:     result.User.Login,
+			CreatedAt: result.Created_at,
+			MergedAt:  result.Merged_at,
+			ClosedAt:  result.Closed_at,
+			Raw:       rawJson(cfg, rc, &raw),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		pullsUpdated.Inc()
+		atomic.AddInt64(&stats.pullsUpdated, 1)
+	}
+}
+
+// the body captured while decoding, for --raw; the decoder may stop
+// short of the end, so drain the rest through the tee first
+func rawJson(cfg *Config, rc io.Reader, raw *bytes.Buffer) json.RawMessage {
+	if !cfg.Raw {
+		return nil
 	}
+	io.Copy(ioutil.Discard, rc)
+
+	return bytes.TrimSpace(raw.Bytes())
 }
 
 // http://developer.github.com/v3/pulls/#get-a-single-pull-request
-func pullUrl(repo string, number int) string {
+func pullUrl(org, repo string, number int) string {
 	return fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls/%d", org, repo, number)
 }
 
-// list pull
-func pull(id, repo string, number int) {
-	requests(pullUrl(repo, number), pullHandler(id, repo, number), nil)
+// list pull; a 304 leaves the row as it was, unless it's still pending
+func pull(ctx context.Context, cfg *Config, st Store, org, id, repo string, number int, pending bool) {
+	ctx, span := startTask(ctx, cfg, "pull", org, repo)
+	defer span.End()
+
+	url := pullUrl(org, repo, number)
+	status := requests(ctx, cfg, url, pullHandler(ctx, cfg, st, org, id, repo, number), st)
+	if status == 304 && pending {
+		status = requests(ctx, cfg, url, pullHandler(ctx, cfg, st, org, id, repo, number), fullLookup{st})
+	}
+	if failed(status) {
+		failedLookup(ctx, cfg, st, "pull", id, org, repo, url, status)
+	}
 }
 
 // shas request processing
-func commitHandler(id, repo, sha string) handler {
+func commitHandler(ctx context.Context, cfg *Config, st Store, org, id, repo, sha string) handler {
 	return func(rc io.Reader) {
 		// http://developer.github.com/v3/repos/commits/#get-a-single-commit
 		var result struct {
 			Commit struct {
 				Message string
 				Author  struct {
+					Name  string
+					Email string
+					Date  string
+				}
+				Committer struct {
 					Email string
 					Date  string
 				}
+				// unsigned commits are verified=false, reason=unsigned
+				Verification struct {
+					Verified bool
+					Reason   string
+				}
+			}
+			Author *struct {
+				Login string
 			}
 			Stats struct {
 				Additions int
 				Deletions int
 				Total     int
 			}
+			Files   []commitFile
+			Parents []struct {
+				Sha string
+			}
 		}
 
+		var raw bytes.Buffer
+		if cfg.Raw {
+			rc = io.TeeReader(rc, &raw)
+		}
 		if err := json.NewDecoder(rc).Decode(&result); err != nil {
-			log.Printf("fn=commitHandler err=%v org=%v repo=%v sha=%v id=%v\n", err, org, repo, sha, id)
+			warnf("fn=commitHandler err=%v org=%v repo=%v sha=%v id=%v\n", err, org, repo, sha, id)
 			return
 		}
 
-		log.Printf("fn=commitHandler org=%v repo=%v sha=%v id=%v\n", org, repo, sha, id)
-		updateCommits(id,
-			result.Commit.Author.Email,
-			result.Commit.Author.Date,
-			result.Commit.Message,
-			result.Stats.Additions,
-			result.Stats.Deletions,
-			result.Stats.Total)
+		var login *string
+		if result.Author != nil {
+			login = &result.Author.Login
+		}
+
+		// files first, as setting email marks the commit done
+		debugf("fn=commitHandler org=%v repo=%v sha=%v id=%v\n", org, repo, sha, id)
+		if err := st.CreateCommitFiles(ctx, id, result.Files); err != nil {
+			log.Fatal(err)
+		}
+		if cfg.Parents {
+			parents := make([]string, len(result.Parents))
+			for i, p := range result.Parents {
+				parents[i] = p.Sha
+			}
+			if err := st.CreateCommitParents(ctx, id, parents); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := st.UpdateCommit(ctx, id, commitMeta{
+			Email:              anonymize(cfg, result.Commit.Author.Email),
+			Date:               result.Commit.Author.Date,
+			Message:            result.Commit.Message,
+			Additions:          result.Stats.Additions,
+			Deletions:          result.Stats.Deletions,
+			Total:              result.Stats.Total,
+			Name:               result.Commit.Author.Name,
+			Login:              login,
+			CommitterEmail:     anonymize(cfg, result.Commit.Committer.Email),
+			CommitterDate:      result.Commit.Committer.Date,
+			Verified:           result.Commit.Verification.Verified,
+			VerificationReason: result.Commit.Verification.Reason,
+			Raw:                rawJson(cfg, rc, &raw),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		commitsUpdated.Inc()
+		atomic.AddInt64(&stats.commitsUpdated, 1)
+	}
+}
+
+// email as a hex hmac with --anonymize-emails, so the same person still
+// groups together without being recoverable
+func anonymize(cfg *Config, email string) string {
+	if !cfg.Anonymize || email == "" {
+		return email
 	}
+
+	mac := hmac.New(sha256.New, cfg.EmailKey)
+	mac.Write([]byte(strings.ToLower(email)))
+
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 // http://developer.github.com/v3/repos/commits/#get-a-single-commit
-func commitUrl(repo, sha string) string {
+func commitUrl(org, repo, sha string) string {
 	return fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", org, repo, sha)
 }
 
-// list sha
-func commit(id, repo, sha string) {
-	requests(commitUrl(repo, sha), commitHandler(id, repo, sha), nil)
-}
-
-// commits request processing
-func commitsHandler(repo string) handler {
+// check runs request processing, gathering every page's runs
+func checkRunsHandler(org, repo, sha string, runs *[]checkRun) handler {
 	return func(rc io.Reader) {
-		// http://developer.github.com/v3/repos/commits/#list-commits-on-a-repository
-		var result []struct {
-			Sha string
+		// https://docs.github.com/en/rest/checks/runs#list-check-runs-for-a-git-reference
+		var result struct {
+			Check_runs []struct {
+				Name         string
+				Status       string
+				Conclusion   *string
+				Started_at   *string
+				Completed_at *string
+			}
 		}
 		if err := json.NewDecoder(rc).Decode(&result); err != nil {
-			log.Printf("fn=commitsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			warnf("fn=checkRunsHandler err=%v org=%v repo=%v sha=%v\n", err, org, repo, sha)
 			return
 		}
 
-		// walk through shas, adding them to db if not present
-		for _, c := range result {
-			log.Printf("fn=commitsHandler org=%v repo=%v sha=%v\n", org, repo, c.Sha)
-			findOrCreateCommits(repo, c.Sha)
+		for _, r := range result.Check_runs {
+			*runs = append(*runs, checkRun{Name: r.Name, Status: r.Status, Conclusion: r.Conclusion, StartedAt: r.Started_at, CompletedAt: r.Completed_at})
 		}
 	}
 }
 
-// bake in since and until values
-// http://developer.github.com/v3/repos/commits/#list-commits-on-a-repository
-func commitsUrlFormat() (url string) {
-	url = "https://api.github.com/repos/%s/%s/commits?"
-	if *since != "" {
-		url += fmt.Sprintf("since=%s&", *since)
-	}
-	if *until != "" {
-		url += fmt.Sprintf("until=%s", *until)
+// look up sha's check runs, saved once every page is in; a sha without
+// any, or gone from github, is still marked checked
+func checkRuns(ctx context.Context, cfg *Config, st Store, org, id, repo, sha string) {
+	ctx, span := startTask(ctx, cfg, "checkRuns", org, repo)
+	defer span.End()
+
+	var runs []checkRun
+	status := requests(ctx, cfg, fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s/check-runs?%s", org, repo, sha, perPage(cfg)), checkRunsHandler(org, repo, sha, &runs), nil)
+	if status != 200 && status != 404 {
+		return
 	}
 
-	return
+	debugf("fn=checkRuns org=%v repo=%v sha=%v check_runs=%v\n", org, repo, sha, len(runs))
+	if err := st.SaveCheckRuns(ctx, id, runs); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func commitsUrl(repo string) string {
-	return fmt.Sprintf(commitsUrlFormat(), org, repo)
-}
+// lookups of a sha whose stats github is still computing (202), --delay
+// apart, before it's left pending for the next pass
+const computingAttempts = 3
 
-// list commits
-func commits(repo string) {
-	requests(commitsUrl(repo), commitsHandler(repo), nil)
-}
+// closure to lookup sha, enqueued again after --delay on a 202 rather than
+// tying up the worker; done counts it until it's through
+func lookupCommit(ctx context.Context, cfg *Config, st Store, c chan<- func(), done *sync.WaitGroup, org string, p pendingCommit, attempt int) func() {
+	return func() {
+		defer done.Done()
 
-// pulls request processing
-func pullsHandler(repo string) handler {
-	return func(rc io.Reader) {
-		// http://developer.github.com/v3/pulls/#list-pull-requests
-		var result []struct {
-			Number int
+		if commit(ctx, cfg, st, org, p.Id, p.Repo, p.Sha) != 202 {
+			return
 		}
-		if err := json.NewDecoder(rc).Decode(&result); err != nil {
-			log.Printf("fn=pullsHandler err=%v org=%v repo=%v\n", err, org, repo)
+		if attempt >= computingAttempts {
+			infof("fn=lookupCommit org=%v repo=%v sha=%v id=%v attempts=%v at=still-computing\n", org, p.Repo, p.Sha, p.Id, attempt)
 			return
 		}
 
-		// walk through pulls, adding them to db if not present
-		for _, c := range result {
-			log.Printf("fn=pullsHandler org=%v repo=%v number=%v\n", org, repo, c.Number)
-			findOrCreatePulls(repo, c.Number)
-		}
+		done.Add(1)
+		time.AfterFunc(time.Duration(cfg.Delay)*time.Second, func() {
+			c <- lookupCommit(ctx, cfg, st, c, done, org, p, attempt+1)
+		})
 	}
 }
 
-// bake in since and until values
-// http://developer.github.com/v3/pulls/#list-pull-requests
-func pullsUrlFormat() (url string) {
-	url = "https://api.github.com/repos/%s/%s/pulls?state=closed&"
-	if *since != "" {
-		url += fmt.Sprintf("since=%s&", *since)
+// list sha, marking it missing if it's gone, and returning the last status
+func commit(ctx context.Context, cfg *Config, st Store, org, id, repo, sha string) int {
+	ctx, span := startTask(ctx, cfg, "commit", org, repo)
+	defer span.End()
+
+	url := commitUrl(org, repo, sha)
+	status := requests(ctx, cfg, url, commitHandler(ctx, cfg, st, org, id, repo, sha), st)
+	// only pending shas are looked up, so a 304 is no reason to leave it
+	if status == 304 {
+		status = requests(ctx, cfg, url, commitHandler(ctx, cfg, st, org, id, repo, sha), fullLookup{st})
 	}
-	if *until != "" {
-		url += fmt.Sprintf("until=%s", *until)
+	if status == 404 {
+		infof("fn=commit org=%v repo=%v sha=%v id=%v at=missing\n", org, repo, sha, id)
+		if err := st.MissingCommit(ctx, id); err != nil {
+			log.Fatal(err)
+		}
+	} else if failed(status) {
+		failedLookup(ctx, cfg, st, "commit", id, org, repo, url, status)
 	}
 
-	return
+	return status
 }
 
-func pullsUrl(repo string) string {
-	return fmt.Sprintf(pullsUrlFormat(), org, repo)
+// lookup statuses worth counting against a row: errors github sent back,
+// not 404s, which are missing commits, nor 0s, which never reached github
+func failed(status int) bool {
+	return status >= 400 && status != 404
 }
 
-// list pulls
-func pulls(repo string) {
-	requests(pullsUrl(repo), pullsHandler(repo), nil)
+// count a failed lookup; each updater pass is another attempt, and after
+// --max-attempts the row is dead lettered rather than looked up forever
+func failedLookup(ctx context.Context, cfg *Config, st Store, kind, id, org, repo, url string, status int) {
+	dead, err := st.FailedLookup(ctx, kind, id, org, repo, url, fmt.Sprintf("status %d", status), cfg.MaxAttempts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dead {
+		warnf("fn=failedLookup kind=%v org=%v repo=%v id=%v url=%q status=%v at=dead-letter\n", kind, org, repo, id, url, status)
+	}
+}
+
+// commits request processing
+func commitsHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// http://developer.github.com/v3/repos/commits/#list-commits-on-a-repository
+		var result []struct {
+			Sha string
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=commitsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		// walk through shas, adding them to db if not present
+		for _, c := range result {
+			debugf("fn=commitsHandler org=%v repo=%v sha=%v\n", org, repo, c.Sha)
+			created, err := st.FindOrCreateCommit(ctx, org, repo, c.Sha)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if created {
+				commitsInserted.Inc()
+				events.emit(org, repo, c.Sha)
+				atomic.AddInt64(&stats.commitsFound, 1)
+			}
+		}
+	}
+}
+
+// page size query param for listings; github's default is 30
+func perPage(cfg *Config) string {
+	return fmt.Sprintf("per_page=%d", cfg.PageSize)
+}
+
+// bake in since and until values
+// http://developer.github.com/v3/repos/commits/#list-commits-on-a-repository
+func commitsUrlFormat(cfg *Config, since, until string) (url string) {
+	url = "https://api.github.com/repos/%s/%s/commits?" + perPage(cfg) + "&"
+	if since != "" {
+		url += fmt.Sprintf("since=%s&", since)
+	}
+	if until != "" {
+		url += fmt.Sprintf("until=%s", until)
+	}
+
+	return
+}
+
+// branch, if set, goes on after formatting as names can hold a %
+func commitsUrl(cfg *Config, org, repo, since, until, branch string) string {
+	u := fmt.Sprintf(commitsUrlFormat(cfg, since, until), org, repo)
+	if branch != "" {
+		if !strings.HasSuffix(u, "&") {
+			u += "&"
+		}
+		u += "sha=" + url.QueryEscape(branch)
+	}
+
+	return u
+}
+
+// repos whose commit listing hit --task-timeout; commits are listed newest
+// first, so the stored watermark could skip the older pages never reached
+var relist sync.Map
+
+// how far before repo's newest stored commit to list from; github's since
+// is by committer date, which needn't only go forward, e.g. a push of
+// commits made earlier on another branch or a machine with a slow clock
+const commitsOverlap = 24 * time.Hour
+
+// the later of --since and repo's newest stored committer date less
+// commitsOverlap, so a changed repo only lists what's new; a date in the
+// future counts as now. both are iso8601 and compare as strings
+func commitsSince(ctx context.Context, cfg *Config, st Store, org, repo string) string {
+	if _, ok := relist.Load(org + "/" + repo); ok {
+		return cfg.Since
+	}
+
+	latest, err := st.LatestCommitDate(ctx, org, repo)
+	if err != nil {
+		warnf("fn=commitsSince org=%v repo=%v err=%v\n", org, repo, err)
+	}
+	if latest == "" {
+		return cfg.Since
+	}
+
+	t, err := time.Parse(iso8601, latest)
+	if err != nil {
+		warnf("fn=commitsSince org=%v repo=%v err=%v\n", org, repo, err)
+		return cfg.Since
+	}
+	if t.After(time.Now()) {
+		t = time.Now()
+	}
+	if since := t.Add(-commitsOverlap).UTC().Format(iso8601); since > cfg.Since {
+		debugf("fn=commitsSince org=%v repo=%v latest=%v since=%v\n", org, repo, latest, since)
+		return since
+	}
+
+	return cfg.Since
+}
+
+// list commits, on every branch with --all-branches; shas reachable from
+// several branches are only inserted once
+func commits(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "commits", org, repo)
+	defer span.End()
+
+	if cfg.Backfill {
+		backfill(ctx, cfg, st, org, repo)
+		return
+	}
+
+	since := commitsSince(ctx, cfg, st, org, repo)
+	if !cfg.AllBranches {
+		requests(ctx, cfg, commitsUrl(cfg, org, repo, since, cfg.Until, ""), commitsHandler(ctx, st, org, repo), nil)
+	} else {
+		for _, branch := range branches(ctx, cfg, org, repo) {
+			requests(ctx, cfg, commitsUrl(cfg, org, repo, since, cfg.Until, branch), commitsHandler(ctx, st, org, repo), nil)
+		}
+	}
+
+	if expired(ctx) {
+		relist.Store(org+"/"+repo, true)
+	} else {
+		relist.Delete(org + "/" + repo)
+	}
+}
+
+// list repo's commits from --since to --until, or now, a --backfill-chunk
+// at a time, oldest first; each chunk is saved once every page of it is
+// in, so a rerun over the same window picks up after the last one
+func backfill(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	start, err := time.Parse(iso8601, cfg.Since)
+	if err != nil {
+		log.Fatal(err)
+	}
+	end := time.Now().UTC()
+	if cfg.Until != "" {
+		if end, err = time.Parse(iso8601, cfg.Until); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	done, err := st.LoadBackfill(ctx, org, repo, cfg.Since, cfg.Until)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if t, err := time.Parse(iso8601, done); err == nil && t.After(start) {
+		debugf("fn=backfill org=%v repo=%v done=%v at=resume\n", org, repo, done)
+		start = t
+	}
+
+	names := []string{""}
+	if cfg.AllBranches {
+		names = branches(ctx, cfg, org, repo)
+	}
+
+	for start.Before(end) {
+		stop := start.Add(cfg.BackfillChunk)
+		if stop.After(end) {
+			stop = end
+		}
+
+		for _, branch := range names {
+			u := commitsUrl(cfg, org, repo, start.Format(iso8601), stop.Format(iso8601), branch)
+			if status := requests(ctx, cfg, u, commitsHandler(ctx, st, org, repo), nil); status != 200 {
+				warnf("fn=backfill org=%v repo=%v since=%v until=%v status=%v at=stopped\n", org, repo, start.Format(iso8601), stop.Format(iso8601), status)
+				return
+			}
+		}
+
+		if err := st.SaveBackfill(ctx, org, repo, cfg.Since, cfg.Until, stop.Format(iso8601)); err != nil {
+			log.Fatal(err)
+		}
+		debugf("fn=backfill org=%v repo=%v done=%v\n", org, repo, stop.Format(iso8601))
+		start = stop
+	}
+}
+
+// branches request processing
+func branchesHandler(org, repo string, names *[]string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/branches/branches#list-branches
+		var result []struct {
+			Name string
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=branchesHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		for _, b := range result {
+			debugf("fn=branchesHandler org=%v repo=%v branch=%v\n", org, repo, b.Name)
+			*names = append(*names, b.Name)
+		}
+	}
+}
+
+// list branch names
+func branches(ctx context.Context, cfg *Config, org, repo string) (names []string) {
+	requests(ctx, cfg, fmt.Sprintf("https://api.github.com/repos/%s/%s/branches?%s", org, repo, perPage(cfg)), branchesHandler(org, repo, &names), nil)
+
+	return
+}
+
+// pulls request processing
+func pullsHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// http://developer.github.com/v3/pulls/#list-pull-requests
+		var result []struct {
+			Number int
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=pullsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		// walk through pulls, adding them to db if not present
+		for _, c := range result {
+			debugf("fn=pullsHandler org=%v repo=%v number=%v\n", org, repo, c.Number)
+			created, err := st.FindOrCreatePull(ctx, org, repo, c.Number)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if created {
+				pullsInserted.Inc()
+				atomic.AddInt64(&stats.pullsFound, 1)
+			}
+		}
+	}
+}
+
+// bake in since and until values
+// http://developer.github.com/v3/pulls/#list-pull-requests
+func pullsUrlFormat(cfg *Config) (url string) {
+	url = "https://api.github.com/repos/%s/%s/pulls?state=all&" + perPage(cfg) + "&"
+	if cfg.Since != "" {
+		url += fmt.Sprintf("since=%s&", cfg.Since)
+	}
+	if cfg.Until != "" {
+		url += fmt.Sprintf("until=%s", cfg.Until)
+	}
+
+	return
+}
+
+func pullsUrl(cfg *Config, org, repo string) string {
+	return fmt.Sprintf(pullsUrlFormat(cfg), org, repo)
+}
+
+// list pulls
+func pulls(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "pulls", org, repo)
+	defer span.End()
+
+	requests(ctx, cfg, pullsUrl(cfg, org, repo), pullsHandler(ctx, st, org, repo), nil)
+}
+
+// dependabot alerts request processing
+func dependabotAlertsHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/dependabot/alerts#list-dependabot-alerts-for-a-repository
+		var result []struct {
+			Number     int
+			State      string
+			Created_at string
+			Dependency struct {
+				Package struct {
+					Name string
+				}
+			}
+			Security_advisory struct {
+				Severity string
+			}
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=dependabotAlertsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		// walk through alerts, adding or refreshing them in db
+		for _, a := range result {
+			debugf("fn=dependabotAlertsHandler org=%v repo=%v number=%v\n", org, repo, a.Number)
+			if err := st.SaveDependabotAlert(ctx, org, repo, dependabotAlert{
+				Number:    a.Number,
+				Package:   a.Dependency.Package.Name,
+				Severity:  a.Security_advisory.Severity,
+				State:     a.State,
+				CreatedAt: a.Created_at,
+			}); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// https://docs.github.com/en/rest/dependabot/alerts#list-dependabot-alerts-for-a-repository
+func dependabotAlertsUrl(cfg *Config, org, repo string) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/dependabot/alerts?%s", org, repo, perPage(cfg))
+}
+
+// list dependabot alerts; 403 when disabled or token lacks security scope
+func dependabotAlerts(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "dependabotAlerts", org, repo)
+	defer span.End()
+
+	requests(ctx, cfg, dependabotAlertsUrl(cfg, org, repo), dependabotAlertsHandler(ctx, st, org, repo), nil)
+}
+
+// code scanning alerts request processing
+func codeScanningAlertsHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/code-scanning/code-scanning#list-code-scanning-alerts-for-a-repository
+		var result []struct {
+			Number int
+			State  string
+			Rule   struct {
+				Id       string
+				Severity string
+			}
+			Tool struct {
+				Name string
+			}
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=codeScanningAlertsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		// walk through alerts, adding or refreshing them in db
+		for _, a := range result {
+			debugf("fn=codeScanningAlertsHandler org=%v repo=%v number=%v\n", org, repo, a.Number)
+			if err := st.SaveCodeScanningAlert(ctx, org, repo, codeScanningAlert{
+				Number:   a.Number,
+				RuleId:   a.Rule.Id,
+				Severity: a.Rule.Severity,
+				State:    a.State,
+				Tool:     a.Tool.Name,
+			}); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// https://docs.github.com/en/rest/code-scanning/code-scanning#list-code-scanning-alerts-for-a-repository
+func codeScanningAlertsUrl(cfg *Config, org, repo string) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/code-scanning/alerts?%s", org, repo, perPage(cfg))
+}
+
+// list code scanning alerts; 403 or 404 when code scanning isn't enabled
+func codeScanningAlerts(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "codeScanningAlerts", org, repo)
+	defer span.End()
+
+	requests(ctx, cfg, codeScanningAlertsUrl(cfg, org, repo), codeScanningAlertsHandler(ctx, st, org, repo), nil)
+}
+
+// workflow runs request processing
+func workflowRunsHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/actions/workflow-runs#list-workflow-runs-for-a-repository
+		var result struct {
+			Workflow_runs []struct {
+				Id         int64
+				Name       string
+				Event      string
+				Status     string
+				Conclusion *string
+				Head_sha   string
+				Created_at string
+				Updated_at string
+			}
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=workflowRunsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		// walk through runs, adding or refreshing them in db
+		for _, r := range result.Workflow_runs {
+			debugf("fn=workflowRunsHandler org=%v repo=%v run_id=%v\n", org, repo, r.Id)
+			if err := st.SaveWorkflowRun(ctx, org, repo, workflowRun{
+				RunId:      r.Id,
+				Workflow:   r.Name,
+				Event:      r.Event,
+				Status:     r.Status,
+				Conclusion: r.Conclusion,
+				HeadSha:    r.Head_sha,
+				CreatedAt:  r.Created_at,
+				UpdatedAt:  r.Updated_at,
+			}); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// runs created since --since, if set
+func workflowRunsUrl(cfg *Config, org, repo string) string {
+	u := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/runs?%s", org, repo, perPage(cfg))
+	if cfg.Since != "" {
+		u += "&created=" + url.QueryEscape(">="+cfg.Since)
+	}
+
+	return u
+}
+
+// list workflow runs
+func workflowRuns(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "workflowRuns", org, repo)
+	defer span.End()
+
+	requests(ctx, cfg, workflowRunsUrl(cfg, org, repo), workflowRunsHandler(ctx, st, org, repo), nil)
+}
+
+// deployments request processing, gathering every page's deployments
+func deploymentsHandler(org, repo string, ds *[]deployment) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/deployments/deployments#list-deployments
+		var result []struct {
+			Id          int64
+			Environment string
+			Ref         string
+			Sha         string
+			Created_at  string
+			Creator     *struct {
+				Login string
+			}
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=deploymentsHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		for _, d := range result {
+			var creator string
+			if d.Creator != nil {
+				creator = d.Creator.Login
+			}
+			*ds = append(*ds, deployment{DeploymentId: d.Id, Environment: d.Environment, Ref: d.Ref, Sha: d.Sha, Creator: creator, CreatedAt: d.Created_at})
+		}
+	}
+}
+
+// deployment statuses request processing
+func deploymentStatusesHandler(org, repo string, statuses *[]deploymentStatus) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/deployments/statuses#list-deployment-statuses
+		var result []struct {
+			Id         int64
+			State      string
+			Created_at string
+			Creator    *struct {
+				Login string
+			}
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=deploymentStatusesHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		for _, s := range result {
+			var creator string
+			if s.Creator != nil {
+				creator = s.Creator.Login
+			}
+			*statuses = append(*statuses, deploymentStatus{StatusId: s.Id, State: s.State, Creator: creator, CreatedAt: s.Created_at})
+		}
+	}
+}
+
+func deploymentsUrl(cfg *Config, org, repo string) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/deployments?%s", org, repo, perPage(cfg))
+}
+
+func deploymentStatusesUrl(cfg *Config, org, repo string, id int64) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/deployments/%d/statuses?%s", org, repo, id, perPage(cfg))
+}
+
+// list deployments, then each one's statuses; statuses come newest first,
+// so the first is the deployment's state
+func deployments(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "deployments", org, repo)
+	defer span.End()
+
+	var ds []deployment
+	requests(ctx, cfg, deploymentsUrl(cfg, org, repo), deploymentsHandler(org, repo, &ds), nil)
+
+	for _, d := range ds {
+		var statuses []deploymentStatus
+		requests(ctx, cfg, deploymentStatusesUrl(cfg, org, repo, d.DeploymentId), deploymentStatusesHandler(org, repo, &statuses), nil)
+		if len(statuses) > 0 {
+			d.State = statuses[0].State
+		}
+
+		debugf("fn=deployments org=%v repo=%v deployment_id=%v statuses=%v\n", org, repo, d.DeploymentId, len(statuses))
+		if err := st.SaveDeployment(ctx, org, repo, d); err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			if err := st.SaveDeploymentStatus(ctx, org, repo, d.DeploymentId, s); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+// teams request processing, gathering every page's slugs and names
+func teamsHandler(org string, names map[string]string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/teams/teams#list-teams
+		var result []struct {
+			Slug string
+			Name string
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=teamsHandler err=%v org=%v\n", err, org)
+			return
+		}
+
+		for _, t := range result {
+			names[t.Slug] = t.Name
+		}
+	}
+}
+
+// team members request processing
+func teamMembersHandler(org, slug string, logins *[]string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/teams/members#list-team-members
+		var result []struct {
+			Login string
+		}
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=teamMembersHandler err=%v org=%v slug=%v\n", err, org, slug)
+			return
+		}
+
+		for _, m := range result {
+			*logins = append(*logins, m.Login)
+		}
+	}
+}
+
+// list org's teams, then each one's members; needs a token with read:org
+func teams(ctx context.Context, cfg *Config, st Store, org string) {
+	ctx, span := startTask(ctx, cfg, "teams", org, "")
+	defer span.End()
+
+	names := make(map[string]string)
+	status := requests(ctx, cfg, fmt.Sprintf("https://api.github.com/orgs/%s/teams?%s", org, perPage(cfg)), teamsHandler(org, names), nil)
+	if status == 403 || status == 404 {
+		errorf("fn=teams org=%v status=%v at=error msg=\"listing teams needs a token with read:org, or an org, not a user\"\n", org, status)
+		return
+	}
+
+	for slug, name := range names {
+		var logins []string
+		if requests(ctx, cfg, fmt.Sprintf("https://api.github.com/orgs/%s/teams/%s/members?%s", org, slug, perPage(cfg)), teamMembersHandler(org, slug, &logins), nil) != 200 {
+			continue
+		}
+
+		debugf("fn=teams org=%v slug=%v members=%v\n", org, slug, len(logins))
+		if err := st.SaveTeam(ctx, org, slug, name); err != nil {
+			log.Fatal(err)
+		}
+		if err := st.SaveTeamMembers(ctx, org, slug, logins); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// languages request processing
+func repoLanguagesHandler(ctx context.Context, st Store, org, repo string) handler {
+	return func(rc io.Reader) {
+		// https://docs.github.com/en/rest/repos/repos#list-repository-languages
+		var result map[string]int
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=repoLanguagesHandler err=%v org=%v repo=%v\n", err, org, repo)
+			return
+		}
+
+		debugf("fn=repoLanguagesHandler org=%v repo=%v languages=%v\n", org, repo, len(result))
+		if err := st.SaveRepoLanguages(ctx, org, repo, result); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func repoLanguagesUrl(org, repo string) string {
+	return fmt.Sprintf("https://api.github.com/repos/%s/%s/languages", org, repo)
+}
+
+// list a repo's bytes per language
+func repoLanguages(ctx context.Context, cfg *Config, st Store, org, repo string) {
+	ctx, span := startTask(ctx, cfg, "repoLanguages", org, repo)
+	defer span.End()
+
+	requests(ctx, cfg, repoLanguagesUrl(org, repo), repoLanguagesHandler(ctx, st, org, repo), nil)
+}
+
+// use repo pushed_at to filter
+func pushedOk(cfg *Config, pushed string) bool {
+	pushedBytes := bytes.NewBufferString(pushed).Bytes()
+	if now != "" {
+		// repo hasn't changed since last loop
+		nowBytes := bytes.NewBufferString(now).Bytes()
+		if bytes.Compare(nowBytes, pushedBytes) == 1 {
+			return false
+		}
+	}
+	if cfg.Since != "" {
+		// repo hasn't changed since since
+		sinceBytes := bytes.NewBufferString(cfg.Since).Bytes()
+		if bytes.Compare(sinceBytes, pushedBytes) == 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// repo as listed, over rest or graphql
+type listedRepo struct {
+	Name      string
+	Pushed_at string
+	Archived  bool
+	Fork      bool
+	Language  *string
+	Topics    []string
+}
+
+// repos request processing
+func reposHandler(ctx context.Context, cfg *Config, st Store, c chan<- func(), org string) handler {
+	return func(rc io.Reader) {
+		// http://developer.github.com/v3/repos/#list-organization-repositories
+		var result []listedRepo
+		if err := json.NewDecoder(rc).Decode(&result); err != nil {
+			warnf("fn=reposHandler err=%v org=%v\n", err, org)
+			return
+		}
+
+		listed(ctx, cfg, st, c, org, result)
+	}
+}
+
+// filter a page of listed repos, harvesting what's left
+func listed(ctx context.Context, cfg *Config, st Store, c chan<- func(), org string, result []listedRepo) {
+	// skip ahead to the repo recorded in the state file, once
+	if resume.Repo != "" {
+		for i, r := range result {
+			if r.Name == resume.Repo {
+				result = result[i:]
+				break
+			}
+		}
+		resume.Repo = ""
+	}
+
+	// walk through repos, if not ignored add to worker
+	for _, r := range result {
+		p := listing.add(r.Name)
+		harvestListed(ctx, cfg, st, c, org, r, p)
+		listing.done(ctx, cfg, st, p)
+	}
+}
+
+// harvest r unless it's filtered out, its tasks counted against p
+func harvestListed(ctx context.Context, cfg *Config, st Store, c chan<- func(), org string, r listedRepo, p *repoProgress) {
+	debugf("fn=listed org=%v repo=%v pushed=%q\n", org, r.Name, r.Pushed_at)
+	if (cfg.SkipArchived && r.Archived) || (cfg.SkipForks && r.Fork) {
+		debugf("fn=listed org=%v repo=%v archived=%v fork=%v at=skip\n", org, r.Name, r.Archived, r.Fork)
+		return
+	}
+	if !languageOk(cfg, r.Language) {
+		debugf("fn=listed org=%v repo=%v at=skip-language\n", org, r.Name)
+		return
+	}
+	if !wanted(cfg, r.Name) {
+		return
+	}
+
+	// topics change without a push, so they're saved either way
+	if cfg.RepoTopics {
+		if err := st.SaveRepoTopics(ctx, org, r.Name, r.Topics); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if pushedOk(cfg, r.Pushed_at) {
+		harvest(ctx, cfg, st, c, org, r.Name, p)
+	}
+}
+
+// primary language in --languages, when set; repos github couldn't
+// detect a language for only with --languages-empty
+func languageOk(cfg *Config, lang *string) bool {
+	if cfg.Languages == "" {
+		return true
+	}
+	if lang == nil || *lang == "" {
+		return cfg.LanguagesEmpty
+	}
+
+	for _, l := range splitList(cfg.Languages) {
+		if strings.EqualFold(l, *lang) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// in INCLUDE_REPOS, when set, and not in IGNORE_REPOS
+func wanted(cfg *Config, repo string) bool {
+	if !cfg.Includes.empty() && !cfg.Includes.match(repo) {
+		return false
+	}
+
+	return !cfg.Ignores.match(repo)
+}
+
+// add a repo's listings to worker, counted against p when it's from the
+// org listing
+func harvest(ctx context.Context, cfg *Config, st Store, c chan<- func(), org, repo string, p *repoProgress) {
+	atomic.AddInt64(&stats.repos, 1)
+	if !cfg.PullsOnly {
+		c <- listing.task(ctx, cfg, st, p, bounded(func() { commits(ctx, cfg, st, org, repo) }))
+	}
+	if !cfg.CommitsOnly {
+		c <- listing.task(ctx, cfg, st, p, bounded(func() { pulls(ctx, cfg, st, org, repo) }))
+	}
+	if cfg.Dependabot {
+		c <- listing.task(ctx, cfg, st, p, func() { dependabotAlerts(ctx, cfg, st, org, repo) })
+	}
+	if cfg.CodeScanning {
+		c <- listing.task(ctx, cfg, st, p, func() { codeScanningAlerts(ctx, cfg, st, org, repo) })
+	}
+	if cfg.RepoLanguages {
+		c <- listing.task(ctx, cfg, st, p, func() { repoLanguages(ctx, cfg, st, org, repo) })
+	}
+	if cfg.WorkflowRuns {
+		c <- listing.task(ctx, cfg, st, p, bounded(func() { workflowRuns(ctx, cfg, st, org, repo) }))
+	}
+	if cfg.Deployments {
+		c <- listing.task(ctx, cfg, st, p, bounded(func() { deployments(ctx, cfg, st, org, repo) }))
+	}
+}
+
+// http://developer.github.com/v3/repos/#list-organization-repositories
+// http://developer.github.com/v3/repos/#list-user-repositories
+func reposUrl(cfg *Config, org string) string {
+	if cfg.OwnerType == "user" {
+		return fmt.Sprintf("https://api.github.com/users/%s/repos?%s", org, perPage(cfg))
+	}
+
+	return fmt.Sprintf("https://api.github.com/orgs/%s/repos?%s", org, perPage(cfg))
+}
+
+// list repos, an org at a time
+func repos(ctx context.Context, cfg *Config, st Store, c chan<- func()) {
+	infof("fn=repos now=%v next=%v\n", now, next)
+
+	// resume from the recorded org and page, checkpointing each page as we go
+	start := 0
+	for i, org := range cfg.Orgs {
+		if resume.Url != "" && resume.Org == org {
+			start = i
+		}
+	}
+	for _, org := range cfg.Orgs[start:] {
+		if cfg.GraphqlRepos {
+			listing.at(ctx, cfg, st, state{Org: org})
+			graphqlRepos(ctx, cfg, st, c, org)
+			continue
+		}
+
+		url, repo := reposUrl(cfg, org), ""
+		if resume.Url != "" && resume.Org == org {
+			url, repo = resume.Url, resume.Repo
+		}
+		for url != "" {
+			listing.at(ctx, cfg, st, state{Org: org, Url: url, Repo: repo})
+			if u, _ := request(ctx, cfg, url, reposHandler(ctx, cfg, st, c, org), nil); u != url {
+				url, repo = u, ""
+			}
+		}
+	}
+	resume = state{}
+	listing.at(ctx, cfg, st, state{})
+
+	if cfg.Teams {
+		for _, org := range cfg.Orgs {
+			c <- func(org string) func() {
+				return func() { teams(ctx, cfg, st, org) }
+			}(org)
+		}
+	}
+
+	infof("fn=repos at=done\n")
+
+	// delay before looping, or close worker channel
+	// and update now, next times for filtering repos; the next pass runs
+	// beside the workers too, never on one, as it blocks on a full channel
+	if cfg.Loop {
+		time.Sleep(loopDelay(cfg))
+		now, next = next, time.Now().Format(iso8601)
+		go repos(ctx, cfg, st, c)
+	} else {
+		pg.Done()
+	}
 }
 
-// use repo pushed_at to filter
-func pushedOk(pushed string) bool {
-	pushedBytes := bytes.NewBufferString(pushed).Bytes()
-	if now != "" {
-		// repo hasn't changed since last loop
-		nowBytes := bytes.NewBufferString(now).Bytes()
-		if bytes.Compare(nowBytes, pushedBytes) == 1 {
-			return false
-		}
+// hold a --repo-concurrency slot while f pages through a listing
+func bounded(f func()) func() {
+	if repoSem == nil {
+		return f
 	}
-	if *since != "" {
-		// repo hasn't changed since since
-		sinceBytes := bytes.NewBufferString(*since).Bytes()
-		if bytes.Compare(sinceBytes, pushedBytes) == 1 {
+
+	return func() {
+		repoSem <- struct{}{}
+		defer func() { <-repoSem }()
+		f()
+	}
+}
+
+// names go into api urls as is, so nothing that could change the path
+func repoOk(name string) bool {
+	if !repoRe.MatchString(name) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "." || part == ".." {
 			return false
 		}
 	}
@@ -469,66 +1855,301 @@ func pushedOk(pushed string) bool {
 	return true
 }
 
-// repos request processing
-func reposHandler(c chan<- func()) handler {
+// list only the repos named by --repos, skipping the org listing; names
+// given as org/repo go to that org, bare names to every org
+func namedRepos(ctx context.Context, cfg *Config, st Store, c chan<- func(), names []string) {
+	infof("fn=namedRepos repos=%v\n", len(names))
+	for _, name := range names {
+		if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+			harvest(ctx, cfg, st, c, parts[0], parts[1], nil)
+			continue
+		}
+		for _, org := range cfg.Orgs {
+			harvest(ctx, cfg, st, c, org, name, nil)
+		}
+	}
+
+	// delay before looping, or close worker channel
+	if cfg.Loop {
+		time.Sleep(loopDelay(cfg))
+		go namedRepos(ctx, cfg, st, c, names)
+	} else {
+		pg.Done()
+	}
+}
+
+// repo names request processing
+func repoNamesHandler(org string, names map[string]bool) handler {
 	return func(rc io.Reader) {
 		// http://developer.github.com/v3/repos/#list-organization-repositories
 		var result []struct {
-			Name      string
-			Pushed_at string
+			Name string
 		}
-
 		if err := json.NewDecoder(rc).Decode(&result); err != nil {
-			log.Printf("fn=reposHandler err=%v org=%v\n", err, org)
+			warnf("fn=repoNamesHandler err=%v org=%v\n", err, org)
 			return
 		}
 
-		// walk through repos, if not ignored add to worker
 		for _, r := range result {
-			log.Printf("fn=reposHandler org=%v repo=%v pushed=%q\n", org, r.Name, r.Pushed_at)
-			if !ignores[r.Name] && pushedOk(r.Pushed_at) {
-				c <- func(repo string) func() { return func() { commits(repo) } }(r.Name)
-				c <- func(repo string) func() { return func() { pulls(repo) } }(r.Name)
-			}
+			names[r.Name] = true
 		}
 	}
 }
 
-// http://developer.github.com/v3/repos/#list-organization-repositories
-func reposUrl() string {
-	return fmt.Sprintf("https://api.github.com/orgs/%s/repos", org)
+// erase --forget's email from what's stored, without touching the github
+// api; with --anonymize-emails it's the hmac that's stored, so match that
+func forget(ctx context.Context, cfg *Config, st *sqlStore) {
+	authored, committed, err := st.Forget(ctx, anonymize(cfg, cfg.Forget))
+	if err != nil {
+		log.Fatal(err)
+	}
+	infof("fn=forget authored=%v committed=%v\n", authored, committed)
 }
 
-// list repos
-func repos(c chan<- func(), etags map[string]string) {
-	log.Printf("fn=repos now=%v next=%v\n", now, next)
-	requests(reposUrl(), reposHandler(c), etags)
+// delete rows for repos the org no longer lists, only logging them
+// unless --confirm; a listing that doesn't finish cleanly deletes nothing
+func deleter(ctx context.Context, cfg *Config, st Store, org string) {
+	present := make(map[string]bool)
+	if status := requests(ctx, cfg, reposUrl(cfg, org), repoNamesHandler(org, present), nil); status != 200 || len(present) == 0 {
+		warnf("fn=deleter org=%v status=%v repos=%v at=incomplete-listing\n", org, status, len(present))
+		return
+	}
 
-	log.Println("fn=repos at=done")
+	stored, err := st.QueryRepos(ctx, org)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// delay before looping, or close worker channel
-	// and update now, next times for filtering repos
-	if *loop {
-		time.Sleep(time.Duration(*delay) * time.Second)
-		now, next = next, time.Now().Format(iso8601)
-		c <- func() { repos(c, etags) }
-	} else {
-		pg.Done()
+	for _, repo := range stored {
+		if present[repo] {
+			continue
+		}
+		if !cfg.Confirm {
+			infof("fn=deleter org=%v repo=%v at=would-delete\n", org, repo)
+			continue
+		}
+
+		n, err := st.DeleteRepo(ctx, org, repo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		infof("fn=deleter org=%v repo=%v count=%v at=deleted\n", org, repo, n)
+	}
+}
+
+// overall run progress, persisted to the progress table and the state file
+type state struct {
+	Org     string `json:"org"`
+	Repo    string `json:"repo"`
+	Url     string `json:"url"`
+	Updated string `json:"updated"`
+}
+
+// read state file, or the progress table without one, resuming only if
+// it's for one of our orgs; --full starts over regardless
+func loadState(ctx context.Context, cfg *Config, st Store) {
+	if cfg.Full {
+		return
+	}
+	if cfg.StateFile == "" {
+		// graphql listings have no page urls to resume from
+		if cfg.GraphqlRepos {
+			return
+		}
+
+		s, err := st.LoadProgress(ctx, cfg.Orgs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s.Org != "" {
+			infof("fn=loadState org=%v repo=%v url=%q updated=%v\n", s.Org, s.Repo, s.Url, s.Updated)
+			resume = s
+		}
+		return
+	}
+
+	b, err := ioutil.ReadFile(cfg.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, org := range cfg.Orgs {
+		if s.Org == org {
+			infof("fn=loadState org=%v repo=%v url=%q updated=%v\n", s.Org, s.Repo, s.Url, s.Updated)
+			resume = s
+		}
+	}
+}
+
+// repos listed so far, in order, each held until the tasks harvested for
+// it are through; the checkpoint is the first repo not yet finished, else
+// the page being listed, so a resume never skips an unfinished repo
+type listingProgress struct {
+	sync.Mutex
+	page  state
+	repos []*repoProgress
+}
+
+// where a listed repo was found, and how many of its tasks are still out
+type repoProgress struct {
+	state
+	tasks int
+}
+
+var listing listingProgress
+
+// listing has moved on to page p, or finished with an empty p
+func (l *listingProgress) at(ctx context.Context, cfg *Config, st Store, p state) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.page = p
+	l.checkpoint(ctx, cfg, st)
+}
+
+// hold repo, from the page being listed, until done's been called for
+// the hold and for each task
+func (l *listingProgress) add(repo string) *repoProgress {
+	l.Lock()
+	defer l.Unlock()
+
+	p := &repoProgress{state: state{Org: l.page.Org, Url: l.page.Url, Repo: repo}, tasks: 1}
+	l.repos = append(l.repos, p)
+
+	return p
+}
+
+// f, counted against p until it returns; a nil p isn't tracked
+func (l *listingProgress) task(ctx context.Context, cfg *Config, st Store, p *repoProgress, f func()) func() {
+	if p == nil {
+		return f
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	p.tasks++
+
+	return func() {
+		defer l.done(ctx, cfg, st, p)
+		f()
+	}
+}
+
+// one of p's tasks, or its hold, is through; once the oldest repos are
+// all finished, the checkpoint moves past them
+func (l *listingProgress) done(ctx context.Context, cfg *Config, st Store, p *repoProgress) {
+	l.Lock()
+	defer l.Unlock()
+
+	p.tasks--
+	n := 0
+	for n < len(l.repos) && l.repos[n].tasks == 0 {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	l.repos = l.repos[n:]
+	l.checkpoint(ctx, cfg, st)
+}
+
+// record the first unfinished repo, else the page; called with the lock
+// held, so checkpoints are written one at a time and in order
+func (l *listingProgress) checkpoint(ctx context.Context, cfg *Config, st Store) {
+	p := l.page
+	if len(l.repos) > 0 {
+		p = l.repos[0].state
+	}
+	checkpoint(ctx, cfg, st, p)
+}
+
+// record progress in the store and write state file; written to a temp
+// file first so a crash never leaves a truncated snapshot
+func checkpoint(ctx context.Context, cfg *Config, st Store, p state) {
+	p.Updated = time.Now().Format(iso8601)
+	if err := st.SaveProgress(ctx, cfg.Orgs, p); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.StateFile == "" {
+		return
+	}
+
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tmp := cfg.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp, cfg.StateFile); err != nil {
+		log.Fatal(err)
 	}
 }
 
 // worker loops on func's to call
 func worker(c <-chan func()) {
+	atomic.AddInt64(&startedWorkers, 1)
+	atomic.AddInt64(&liveWorkers, 1)
 	defer wg.Done()
+	defer atomic.AddInt64(&liveWorkers, -1)
 	for f := range c {
+		tasksInFlight.Inc()
 		f()
+		tasksInFlight.Dec()
+		progressed()
+	}
+}
+
+// n, unless it's 0 for the default
+func orScale(n, def int) int {
+	if n == 0 {
+		return def
+	}
+
+	return n
+}
+
+// workers across every pool this run starts, each with the same fallbacks
+// main passes to workers
+func poolSize(cfg *Config) int {
+	n := 0
+	if cfg.Inserter {
+		n += orScale(cfg.InsertScale, cfg.Scale)
+	}
+	if cfg.Updater {
+		update := orScale(cfg.UpdateScale, cfg.Scale)
+		if !cfg.PullsOnly {
+			n += orScale(cfg.CommitScale, update)
+		}
+		if !cfg.CommitsOnly {
+			n += orScale(cfg.PullScale, update)
+		}
+		if cfg.CheckRuns && !cfg.PullsOnly {
+			n += update
+		}
 	}
+
+	return orScale(n, cfg.Scale)
 }
 
-// setup channel and workers
-func workers(c <-chan func()) {
-	wg.Add(*scale)
-	for i := 0; i < *scale; i++ {
+// setup channel and n workers, falling back to --scale
+func workers(cfg *Config, c <-chan func(), n int) {
+	if n == 0 {
+		n = cfg.Scale
+	}
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
 		go worker(c)
 	}
 }
@@ -537,47 +2158,387 @@ func main() {
 	log.SetFlags(log.Lshortfile)
 	log.SetPrefix("app=prism ")
 
-	flag.Parse()
+	cfg := parseConfig()
+	if cfg.LogFormat == "json" {
+		log.SetOutput(jsonWriter{os.Stderr})
+	}
+	if slack = newSlackNotifier(cfg); slack != nil {
+		log.SetOutput(slackWriter{log.Writer(), slack})
+	}
+	minLevel = parseLevel(cfg.LogLevel)
+	client = newClient(cfg)
+	if minLevel <= levelDebug {
+		client = timedDoer{client}
+	}
+	events = newEventSink(cfg)
+	limiter.SetBurst(cfg.Scale)
+	if cfg.OwnerType != "org" && cfg.OwnerType != "user" {
+		log.Fatalf("unknown owner type %q", cfg.OwnerType)
+	}
+	if cfg.WebhookAddr != "" && !cfg.Inserter {
+		log.Fatal("--webhook-addr feeds the inserter's workers, so needs --inserter")
+	}
+	if cfg.GraphqlRepos && cfg.StateFile != "" {
+		log.Fatal("--state-file resumes rest listing pages, which --graphql-repos doesn't use")
+	}
+	if cfg.CommitsOnly && cfg.PullsOnly {
+		log.Fatal("--commits-only and --pulls-only leave nothing to do")
+	}
+	if cfg.Backfill {
+		if _, err := time.Parse(iso8601, cfg.Since); err != nil {
+			log.Fatalf("--backfill needs --since like %v", iso8601)
+		}
+		if _, err := time.Parse(iso8601, cfg.Until); cfg.Until != "" && err != nil {
+			log.Fatalf("--until must be like %v with --backfill", iso8601)
+		}
+		if cfg.BackfillChunk <= 0 {
+			log.Fatal("--backfill-chunk must be positive")
+		}
+	}
+	if cfg.Anonymize && cfg.Raw {
+		log.Fatal("--raw would store the emails --anonymize-emails hides")
+	}
+	for _, name := range splitList(cfg.Repos) {
+		if !repoOk(name) {
+			log.Fatalf("bad repo name %q in --repos", name)
+		}
+	}
+	if cfg.PageSize < 1 || cfg.PageSize > 100 {
+		log.Fatalf("--page-size %v not between 1 and 100", cfg.PageSize)
+	}
+	if cfg.RepoConcurrency > 0 {
+		repoSem = make(chan struct{}, cfg.RepoConcurrency)
+	}
+
+	ctx := context.Background()
+	if cfg.Forget != "" {
+		forget(ctx, cfg, openStore(cfg, mustGetenv("DATABASE_URL")))
+		return
+	}
+	if cfg.Report != "" {
+		report(ctx, cfg, openStore(cfg, mustGetenv("DATABASE_URL")), cfg.Report)
+		return
+	}
+
+	stopTracing := startTracing(ctx, cfg)
+
+	var st Store
+	switch {
+	case cfg.Store == "mem" && cfg.Output != "db":
+		log.Fatalf("--store mem keeps everything in memory, so can't --output %v", cfg.Output)
+	case cfg.Store == "mem":
+		st = newMemStore()
+	case cfg.Store != "db":
+		log.Fatalf("unknown store %q", cfg.Store)
+	case cfg.Output == "db":
+		st = openStore(cfg, mustGetenv("DATABASE_URL"))
+	case cfg.Output == "json":
+		st = newJsonStore(os.Stdout)
+	default:
+		log.Fatalf("unknown output %q", cfg.Output)
+	}
+	serveApi(cfg, st)
+	serveHealth(cfg, st)
+	if cfg.DryRun {
+		st = dryStore{st}
+	}
+	if cfg.OtelEndpoint != "" {
+		st = tracedStore{st}
+	}
+
+	if cfg.Migrate {
+		if err := st.Migrate(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.ResetMissing {
+		for _, org := range cfg.Orgs {
+			n, err := st.ResetMissingCommits(ctx, org)
+			if err != nil {
+				log.Fatal(err)
+			}
+			infof("fn=resetMissingCommits org=%v count=%v\n", org, n)
+		}
+	}
+
+	if cfg.RetryDead {
+		for _, org := range cfg.Orgs {
+			n, err := st.RetryDeadLetters(ctx, org)
+			if err != nil {
+				log.Fatal(err)
+			}
+			infof("fn=retryDeadLetters org=%v count=%v\n", org, n)
+		}
+	}
+
+	if cfg.Deleter {
+		for _, org := range cfg.Orgs {
+			deleter(ctx, cfg, st, org)
+		}
+	}
 
-	c := make(chan func())
-	workers(c)
+	loadState(ctx, cfg, st)
 
-	if *inserter {
+	// producers block once a channel's buffer is full, so each pool holds
+	// at most --queue-size waiting tasks plus one running per worker; they
+	// run beside the workers, as one blocked on a worker could deadlock it
+	c, cc, pc, kc := make(chan func(), cfg.QueueSize), make(chan func(), cfg.QueueSize), make(chan func(), cfg.QueueSize), make(chan func(), cfg.QueueSize)
+	pools := map[string]chan func(){"inserter": c, "commits": cc, "pulls": pc, "check_runs": kc}
+	serveMetrics(cfg, pools)
+	dumpOnSignal(pools)
+	servePprof(cfg)
+
+	if cfg.Inserter {
+		workers(cfg, c, cfg.InsertScale)
 		pg.Add(1)
-		c <- func() { repos(c, nil) }
+		// webhook mode never finishes producing
+		if cfg.WebhookAddr != "" {
+			serveWebhook(ctx, cfg, st, c)
+		} else if names := splitList(cfg.Repos); len(names) > 0 {
+			go namedRepos(ctx, cfg, st, c, names)
+		} else {
+			go repos(ctx, cfg, st, c)
+		}
 	}
-	if *updater {
-		pg.Add(2)
-		c <- func() { queryCommits(c) }
-		c <- func() { queryPulls(c) }
+	if cfg.Updater {
+		// json output holds listed shas in memory only, so without a loop
+		// to pick them up later, let the inserter list everything first
+		if cfg.Output == "json" && cfg.Inserter && !cfg.Loop {
+			pg.Wait()
+		}
+
+		// commits and pulls get their own channels and workers, so neither
+		// starves the other; producers run beside the workers, not on
+		// them, since they block until their batches drain
+		if !cfg.PullsOnly {
+			workers(cfg, cc, orScale(cfg.CommitScale, cfg.UpdateScale))
+			pg.Add(1)
+			go queryCommits(ctx, cfg, st, cc)
+		}
+		if !cfg.CommitsOnly {
+			workers(cfg, pc, orScale(cfg.PullScale, cfg.UpdateScale))
+			pg.Add(1)
+			go queryPulls(ctx, cfg, st, pc)
+		}
+		if cfg.CheckRuns && !cfg.PullsOnly {
+			workers(cfg, kc, cfg.UpdateScale)
+			pg.Add(1)
+			go queryCheckRuns(ctx, cfg, st, kc)
+		}
 	}
 
+	// only close once every producer is done sending
 	pg.Wait()
 	close(c)
+	close(cc)
+	close(pc)
+	close(kc)
 	wg.Wait()
+	events.close()
+	stats.log()
+	slack.post(fmt.Sprintf("prism finished: %s", &stats))
+	stopTracing()
+
+	// harvest's done, but keep answering the api
+	if cfg.ApiAddr != "" {
+		select {}
+	}
 }
 
-func dbOpen(url string) (db *sql.DB) {
-	name, err := pq.ParseURL(url)
-	if err != nil {
-		log.Fatal(err)
+// oauth tokens, each with its own last observed rate limit, rotated to
+// whichever has the most budget left as the one in use runs out
+type tokenPool struct {
+	sync.Mutex
+	sources   []func() string
+	remaining []int
+	resets    []time.Time
+	i         int
+}
+
+// position and authorization header value of the token in use
+func (p *tokenPool) current() (int, string) {
+	p.Lock()
+	i := p.i
+	p.Unlock()
+
+	return i, p.sources[i]()
+}
+
+// position of the token in use, for logging without leaking it
+func (p *tokenPool) index() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.i
+}
+
+// record token i's rate limit from a response made with it
+func (p *tokenPool) observe(i, remaining int, reset time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.remaining[i], p.resets[i] = remaining, reset
+}
+
+// rotate away from token i to the one with the most budget over reserve;
+// false when none has any. a token past its reset, or never used, counts
+// as full
+func (p *tokenPool) rotate(i, reserve int) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	// another worker got here first
+	if p.i != i {
+		return true
 	}
 
-	db, err = sql.Open("postgres", name+" sslmode=disable")
-	if err != nil {
-		log.Fatal(err)
+	best, most := -1, reserve
+	for j := range p.sources {
+		if j == i {
+			continue
+		}
+		n := p.remaining[j]
+		if time.Now().After(p.resets[j]) {
+			n = math.MaxInt32
+		}
+		if n > most {
+			best, most = j, n
+		}
+	}
+	if best < 0 {
+		return false
+	}
+	p.i = best
+
+	return true
+}
+
+// earliest reset of any token, when waiting on them all
+func (p *tokenPool) soonest() time.Time {
+	p.Lock()
+	defer p.Unlock()
+
+	reset := p.resets[0]
+	for _, r := range p.resets[1:] {
+		if r.Before(reset) {
+			reset = r
+		}
+	}
+
+	return reset
+}
+
+// github app installation when configured, else comma separated tokens,
+// falling back to the single OAUTH_TOKEN or OAUTH_TOKEN_FILE
+func makeTokens(list, accept string) *tokenPool {
+	p := &tokenPool{}
+	if os.Getenv("GITHUB_APP_ID") != "" {
+		p.sources = append(p.sources, makeApp(accept).auth)
+	} else {
+		if list == "" {
+			list = oauthToken()
+		}
+		for _, t := range strings.Split(list, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				auth := "token " + t
+				p.sources = append(p.sources, func() string { return auth })
+			}
+		}
+	}
+	if len(p.sources) == 0 {
+		log.Fatal("OAUTH_TOKENS has no tokens")
+	}
+	p.remaining = make([]int, len(p.sources))
+	p.resets = make([]time.Time, len(p.sources))
+
+	return p
+}
+
+// comma separated values, trimmed, without empties
+func splitList(list string) (values []string) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
 	}
 
 	return
 }
 
-func makeIgnored(ignore string) map[string]bool {
-	m := make(map[string]bool)
-	for _, i := range strings.Split(ignore, ",") {
-		m[i] = true
+// repo names, matched exactly, or by pattern: globs like sandbox-* or
+// regexps like /^tmp-\d+$/
+type repoSet struct {
+	names    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func (s repoSet) match(name string) bool {
+	if s.names[name] {
+		return true
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s repoSet) empty() bool {
+	return len(s.names) == 0 && len(s.patterns) == 0
+}
+
+// compile comma separated names and patterns once up front
+func makeRepoSet(list string) repoSet {
+	s := repoSet{names: make(map[string]bool)}
+	for _, v := range splitList(list) {
+		switch {
+		case len(v) > 1 && strings.HasPrefix(v, "/") && strings.HasSuffix(v, "/"):
+			re, err := regexp.Compile(v[1 : len(v)-1])
+			if err != nil {
+				log.Fatalf("bad repo pattern %q: %v", v, err)
+			}
+			s.patterns = append(s.patterns, re)
+		case strings.ContainsAny(v, "*?"):
+			glob := regexp.QuoteMeta(v)
+			glob = strings.ReplaceAll(glob, `\*`, ".*")
+			glob = strings.ReplaceAll(glob, `\?`, ".")
+			s.patterns = append(s.patterns, regexp.MustCompile("^"+glob+"$"))
+		default:
+			s.names[v] = true
+		}
+	}
+
+	return s
+}
+
+func getenv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return def
+}
+
+// the token in OAUTH_TOKEN_FILE, say a mounted secret, else OAUTH_TOKEN
+func oauthToken() string {
+	path := os.Getenv("OAUTH_TOKEN_FILE")
+	if path == "" {
+		return mustGetenv("OAUTH_TOKEN")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		log.Fatalf("OAUTH_TOKEN_FILE %v is empty", path)
 	}
 
-	return m
+	return token
 }
 
 func mustGetenv(key string) (value string) {
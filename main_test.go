@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a Doer answering from h, with rate limit headers to spare
+type stubClient struct {
+	h http.Handler
+}
+
+func (c stubClient) Do(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Ratelimit-Remaining", "4000")
+	rec.Header().Set("X-Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	if req.URL.Path == "/rate_limit" {
+		return rec.Result(), nil
+	}
+	c.h.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// point client at h for the test, returning a config to request with
+func stub(t *testing.T, h http.HandlerFunc) *Config {
+	saved := client
+	client = stubClient{h}
+	t.Cleanup(func() { client = saved })
+
+	return &Config{Tokens: makeTokens("x", ""), Rate: 1000, RateReserve: 10, PageSize: 100, MaxPages: 10, MaxAttempts: 5}
+}
+
+// a memStore holding one pending sha
+func pendingSha(t *testing.T, org, repo, sha string) (*memStore, string) {
+	ctx := context.Background()
+	st := newMemStore()
+	if _, err := st.FindOrCreateCommit(ctx, org, repo, sha); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := st.QueryPendingCommits(ctx, org, "", 1)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending=%v err=%v", pending, err)
+	}
+
+	return st, pending[0].Id
+}
+
+const commitBody = `{"commit": {"message": "m", "author": {"name": "n", "email": "e@x", "date": "2024-01-02T00:00:00Z"}}, "stats": {"additions": 1, "deletions": 2, "total": 3}}`
+
+func TestCommitStaleEtag(t *testing.T) {
+	ctx := context.Background()
+	var sent []string
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("Etag", `"new"`)
+		fmt.Fprint(w, commitBody)
+	})
+	st, id := pendingSha(t, "o", "r", "abc")
+
+	// recorded earlier, though the row never got written
+	url := commitUrl("o", "r", "abc")
+	if err := st.SaveEtag(ctx, url, `"old"`); err != nil {
+		t.Fatal(err)
+	}
+
+	commit(ctx, cfg, st, "o", id, "r", "abc")
+
+	if len(sent) != 2 || sent[0] != `"old"` || sent[1] != "" {
+		t.Errorf("If-None-Match sent %q, want the etag then none", sent)
+	}
+	if m := st.commits[id].Meta; m == nil || m.Email != "e@x" {
+		t.Errorf("meta=%+v, want the commit written", m)
+	}
+	if etag, _ := st.LoadEtag(ctx, url); etag != `"new"` {
+		t.Errorf("etag=%q, want %q", etag, `"new"`)
+	}
+}
+
+func TestPull304LeavesRow(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		fmt.Fprint(w, `{"title": "t", "state": "open", "user": {"login": "u"}}`)
+	})
+	st := newMemStore()
+	if _, err := st.FindOrCreatePull(ctx, "o", "r", 7); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := st.FindPull(ctx, "o", "r", 7)
+
+	pull(ctx, cfg, st, "o", id, "r", 7, true)
+	if m := st.pulls[id].Meta; m == nil || m.Title != "t" {
+		t.Fatalf("meta=%+v, want the pull written", m)
+	}
+
+	// refreshed, unchanged: one request, and the row stays
+	pull(ctx, cfg, st, "o", id, "r", 7, false)
+	if n != 2 {
+		t.Errorf("requests=%v, want 2", n)
+	}
+	if m := st.pulls[id].Meta; m == nil || m.Title != "t" {
+		t.Errorf("meta=%+v, want the pull left as it was", m)
+	}
+}
+
+func TestCommitsSince(t *testing.T) {
+	ctx := context.Background()
+	future := time.Now().Add(48 * time.Hour).UTC().Format(iso8601)
+	for _, c := range []struct {
+		name      string
+		committed string
+		since     string
+		want      string
+	}{
+		{"none stored", "", "2020-01-01T00:00:00Z", "2020-01-01T00:00:00Z"},
+		{"overlap", "2024-03-10T12:00:00Z", "2020-01-01T00:00:00Z", "2024-03-09T12:00:00Z"},
+		{"older than since", "2019-06-01T00:00:00Z", "2020-01-01T00:00:00Z", "2020-01-01T00:00:00Z"},
+		{"future", future, "2020-01-01T00:00:00Z", time.Now().Add(-commitsOverlap).UTC().Format(iso8601)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			st, id := pendingSha(t, "o", "r", "abc")
+			if c.committed != "" {
+				// a future author date, from a bad clock, mustn't count
+				if err := st.UpdateCommit(ctx, id, commitMeta{Date: future, CommitterDate: c.committed}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// to the ten minutes, as now moves on during the test
+			if got := commitsSince(ctx, &Config{Since: c.since}, st, "o", "r"); got[:15] != c.want[:15] {
+				t.Errorf("since=%v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommitComputing(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		// stats still being computed the first time, with a partial body
+		if n++; n == 1 {
+			w.WriteHeader(202)
+			fmt.Fprint(w, `{"commit": {"author": {"email": "e@x"}}}`)
+			return
+		}
+		fmt.Fprint(w, commitBody)
+	})
+	st, id := pendingSha(t, "o", "r", "abc")
+
+	c := make(chan func(), 1)
+	go func() {
+		for f := range c {
+			f()
+		}
+	}()
+	defer close(c)
+
+	var done sync.WaitGroup
+	done.Add(1)
+	c <- func() {
+		lookupCommit(ctx, cfg, st, c, &done, "o", pendingCommit{Id: id, Repo: "r", Sha: "abc"}, 1)()
+		// not written from the partial body
+		if m := st.commits[id].Meta; m != nil {
+			t.Errorf("meta=%+v after a 202, want the sha still pending", m)
+		}
+	}
+	done.Wait()
+
+	if n != 2 {
+		t.Errorf("requests=%v, want 2", n)
+	}
+	if m := st.commits[id].Meta; m == nil || m.Total != 3 {
+		t.Errorf("meta=%+v, want the stats from the 200", m)
+	}
+}
+
+func TestCommitStillComputing(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(202)
+	})
+	st, id := pendingSha(t, "o", "r", "abc")
+
+	c := make(chan func(), 1)
+	go func() {
+		for f := range c {
+			f()
+		}
+	}()
+	defer close(c)
+
+	var done sync.WaitGroup
+	done.Add(1)
+	c <- lookupCommit(ctx, cfg, st, c, &done, "o", pendingCommit{Id: id, Repo: "r", Sha: "abc"}, 1)
+	done.Wait()
+
+	if n != computingAttempts {
+		t.Errorf("requests=%v, want %v", n, computingAttempts)
+	}
+	if pending, _ := st.QueryPendingCommits(ctx, "o", "", 1); len(pending) != 1 {
+		t.Errorf("pending=%v, want the sha left for the next pass", pending)
+	}
+	if st.deadLetters["commit/"+id] != nil {
+		t.Error("dead lettered, want it left pending")
+	}
+}
+
+func TestCommitsEmptyRepo(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(409)
+		fmt.Fprint(w, `{"message": "Git Repository is empty."}`)
+	})
+	cfg.BreakerFailures, cfg.BreakerCooldown = 1, time.Hour
+	st := newMemStore()
+
+	commits(ctx, cfg, st, "o", "empty")
+
+	if n != 1 {
+		t.Errorf("requests=%v, want 1", n)
+	}
+	if len(st.commits) != 0 {
+		t.Errorf("commits=%v, want none", len(st.commits))
+	}
+	if !breakers.allow(commitsUrl(cfg, "o", "empty", "", "", "")) {
+		t.Error("breaker open, want an empty repo left alone")
+	}
+}
+
+func TestRequest409(t *testing.T) {
+	ctx := context.Background()
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(409)
+	})
+
+	for _, c := range []struct {
+		url  string
+		name string
+	}{
+		{commitsUrl(cfg, "o", "r", "", "", ""), "commits"},
+		{"https://api.github.com/repos/o/r/pulls?state=all", "pulls"},
+	} {
+		called := false
+		next, status := request(ctx, cfg, c.url, func(io.Reader) { called = true }, nil)
+		if next != "" || status != 409 || called {
+			t.Errorf("%v: next=%q status=%v called=%v, want no next page, 409, and no handler", c.name, next, status, called)
+		}
+	}
+}
+
+func TestRequestStatus(t *testing.T) {
+	ctx := context.Background()
+	url := "https://api.github.com/repos/o/r/pulls?state=all"
+	nextPage := "https://api.github.com/repos/o/r/pulls?state=all&page=2"
+	for _, c := range []struct {
+		name   string
+		status int
+		header string
+		body   string
+		next   string
+		called bool
+		wait   time.Duration
+	}{
+		{"ok", 200, "", "[]", nextPage, true, 0},
+		{"computing", 202, "", "{}", "", false, 0},
+		{"not modified", 304, "", "", nextPage, false, 0},
+		{"forbidden", 403, "", `{"message": "Resource not accessible by integration"}`, nextPage, false, 0},
+		{"secondary rate limit", 403, "60", `{"message": "You have exceeded a secondary rate limit"}`, url, false, time.Minute},
+		{"secondary rate limit, no retry-after", 403, "", `{"message": "You have exceeded a secondary rate limit"}`, url, false, 7 * time.Second},
+		{"secondary rate limit, retry-after date", 403, "Wed, 21 Oct 2015 07:28:00 GMT", `{"message": "You have exceeded a secondary rate limit"}`, url, false, 7 * time.Second},
+		{"not found", 404, "", `{"message": "Not Found"}`, nextPage, false, 0},
+		{"conflict", 409, "", `{"message": "Conflict"}`, nextPage, false, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPage))
+				if c.header != "" {
+					w.Header().Set("Retry-After", c.header)
+				}
+				w.WriteHeader(c.status)
+				fmt.Fprint(w, c.body)
+			})
+			cfg.Delay = 7
+			var waited time.Duration
+			sleep = func(d time.Duration) { waited += d }
+			t.Cleanup(func() { sleep = time.Sleep })
+
+			called := false
+			next, status := request(ctx, cfg, url, func(io.Reader) { called = true }, nil)
+			if next != c.next || status != c.status || called != c.called {
+				t.Errorf("next=%q status=%v called=%v, want %q %v %v", next, status, called, c.next, c.status, c.called)
+			}
+			if waited != c.wait {
+				t.Errorf("waited %v, want %v", waited, c.wait)
+			}
+		})
+	}
+}
+
+func TestNextUrl(t *testing.T) {
+	const (
+		next = "https://api.github.com/repositories/1/commits?page=2&sha=a%2Cb"
+		prev = "https://api.github.com/repositories/1/commits?page=1"
+		last = "https://api.github.com/repositories/1/commits?page=9"
+	)
+	for _, c := range []struct {
+		name  string
+		links []string
+		want  string
+	}{
+		{"none", nil, ""},
+		{"next only", []string{`<` + next + `>; rel="next"`}, next},
+		{"last only", []string{`<` + last + `>; rel="last"`}, ""},
+		{"next and last", []string{`<` + next + `>; rel="next", <` + last + `>; rel="last"`}, next},
+		{"prev, next, and last", []string{`<` + prev + `>; rel="prev", <` + next + `>; rel="next", <` + last + `>; rel="last"`}, next},
+		{"prev and first, on the last page", []string{`<` + prev + `>; rel="prev", <` + prev + `>; rel="first"`}, ""},
+		{"split across values", []string{`<` + prev + `>; rel="prev"`, `<` + next + `>; rel="next"`, `<` + last + `>; rel="last"`}, next},
+		{"split, no next", []string{`<` + prev + `>; rel="prev"`, `<` + last + `>; rel="last"`}, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := nextUrl(http.Header{"Link": c.links}); got != c.want {
+				t.Errorf("nextUrl=%q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDependabotAlerts(t *testing.T) {
+	ctx := context.Background()
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s&page=2>; rel="next"`, r.URL))
+			fmt.Fprint(w, `[{"number": 1, "state": "open", "created_at": "2024-01-01T00:00:00Z", "dependency": {"package": {"name": "lodash"}}, "security_advisory": {"severity": "high"}}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"number": 2, "state": "fixed", "created_at": "2024-01-02T00:00:00Z", "dependency": {"package": {"name": "left-pad"}}, "security_advisory": {"severity": "low"}}]`)
+	})
+	st := newMemStore()
+
+	dependabotAlerts(ctx, cfg, st, "o", "r")
+
+	want := map[int]dependabotAlert{
+		1: {Number: 1, Package: "lodash", Severity: "high", State: "open", CreatedAt: "2024-01-01T00:00:00Z"},
+		2: {Number: 2, Package: "left-pad", Severity: "low", State: "fixed", CreatedAt: "2024-01-02T00:00:00Z"},
+	}
+	if got := st.dependabot["o/r"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("alerts=%+v, want %+v", got, want)
+	}
+}
+
+func TestDependabotAlertsForbidden(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(403)
+		fmt.Fprint(w, `{"message": "Dependabot alerts are disabled for this repository."}`)
+	})
+	st := newMemStore()
+
+	dependabotAlerts(ctx, cfg, st, "o", "disabled")
+
+	if n != 1 {
+		t.Errorf("requests=%v, want 1 and no retry", n)
+	}
+	if len(st.dependabot) != 0 {
+		t.Errorf("alerts=%+v, want none", st.dependabot)
+	}
+}
+
+func TestCodeScanningAlerts(t *testing.T) {
+	ctx := context.Background()
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s&page=2>; rel="next"`, r.URL))
+			fmt.Fprint(w, `[{"number": 3, "state": "open", "rule": {"id": "js/xss", "severity": "error"}, "tool": {"name": "CodeQL"}}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"number": 4, "state": "dismissed", "rule": {"id": "go/sql-injection", "severity": "warning"}, "tool": {"name": "CodeQL"}}]`)
+	})
+	st := newMemStore()
+
+	codeScanningAlerts(ctx, cfg, st, "o", "r")
+
+	want := map[int]codeScanningAlert{
+		3: {Number: 3, RuleId: "js/xss", Severity: "error", State: "open", Tool: "CodeQL"},
+		4: {Number: 4, RuleId: "go/sql-injection", Severity: "warning", State: "dismissed", Tool: "CodeQL"},
+	}
+	if got := st.scanning["o/r"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("alerts=%+v, want %+v", got, want)
+	}
+}
+
+func TestCodeScanningAlertsNotFound(t *testing.T) {
+	ctx := context.Background()
+	var n int
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(404)
+		fmt.Fprint(w, `{"message": "no analysis found"}`)
+	})
+	st := newMemStore()
+
+	codeScanningAlerts(ctx, cfg, st, "o", "off")
+
+	if n != 1 {
+		t.Errorf("requests=%v, want 1 and no retry", n)
+	}
+	if len(st.scanning) != 0 {
+		t.Errorf("alerts=%+v, want none", st.scanning)
+	}
+}
+
+// start the listing's checkpoints over, as a new process would
+func resetListing(t *testing.T) {
+	listing.page, listing.repos, resume = state{}, nil, state{}
+	t.Cleanup(func() { listing.page, listing.repos, resume = state{}, nil, state{} })
+}
+
+func TestListingCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	resetListing(t)
+	cfg := &Config{Orgs: []string{"o"}, StateFile: t.TempDir() + "/state.json"}
+	st := newMemStore()
+	saved := func() state {
+		p, err := st.LoadProgress(ctx, cfg.Orgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	listing.at(ctx, cfg, st, state{Org: "o", Url: "page1"})
+	a, b := listing.add("a"), listing.add("b")
+	fa := listing.task(ctx, cfg, st, a, func() {})
+	fb := listing.task(ctx, cfg, st, b, func() {})
+	listing.done(ctx, cfg, st, a)
+	listing.done(ctx, cfg, st, b)
+	listing.at(ctx, cfg, st, state{Org: "o", Url: "page2"})
+
+	// b finishing first mustn't move the checkpoint past a
+	fb()
+	if p := saved(); p.Url != "page1" || p.Repo != "a" {
+		t.Errorf("progress=%+v, want page1's a", p)
+	}
+	fa()
+	if p := saved(); p.Url != "page2" || p.Repo != "" {
+		t.Errorf("progress=%+v, want page2", p)
+	}
+
+	listing.at(ctx, cfg, st, state{})
+	if p := saved(); p.Org != "" {
+		t.Errorf("progress=%+v, want none once the listing's through", p)
+	}
+}
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	resetListing(t)
+	var mu sync.Mutex
+	var listings, commitLists []string
+	cfg := stub(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/orgs/o/repos":
+			listings = append(listings, r.URL.Query().Get("page"))
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s&page=2>; rel="next"`, r.URL))
+				fmt.Fprint(w, `[{"name": "a"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"name": "b"}, {"name": "c"}, {"name": "d"}]`)
+		default:
+			commitLists = append(commitLists, r.URL.Path)
+			fmt.Fprint(w, `[]`)
+		}
+	})
+	cfg.Orgs, cfg.CommitsOnly, cfg.StateFile = []string{"o"}, true, t.TempDir()+"/state.json"
+	st := newMemStore()
+
+	// as written by an earlier run that stopped partway through page 2
+	checkpoint(ctx, cfg, st, state{Org: "o", Url: reposUrl(cfg, "o") + "&page=2", Repo: "c"})
+	// leaving only the state file to go on
+	st.progress = make(map[string]state)
+	loadState(ctx, cfg, st)
+
+	withWorkers(func(c chan<- func()) {
+		pg.Add(1)
+		repos(ctx, cfg, st, c)
+	})
+
+	if !reflect.DeepEqual(listings, []string{"2"}) {
+		t.Errorf("listed pages %q, want only page 2", listings)
+	}
+	if want := []string{"/repos/o/c/commits", "/repos/o/d/commits"}; !reflect.DeepEqual(commitLists, want) {
+		t.Errorf("listed commits of %q, want %q", commitLists, want)
+	}
+	if p, _ := st.LoadProgress(ctx, cfg.Orgs); p.Org != "" {
+		t.Errorf("progress=%+v, want none once the run's through", p)
+	}
+}
+
+// a Doer sending api.github.com requests on to srv
+type serverClient struct {
+	srv *httptest.Server
+}
+
+func (c serverClient) Do(req *http.Request) (*http.Response, error) {
+	u, err := url.Parse(c.srv.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+
+	return c.srv.Client().Do(req)
+}
+
+// github, as far as one repo with two commits and a pull goes
+func testGithub(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/o/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"name": "r", "pushed_at": "2024-01-03T00:00:00Z"}]`)
+	})
+	mux.HandleFunc("/repos/o/r/commits", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"sha": "abc"}, {"sha": "def"}]`)
+	})
+	mux.HandleFunc("/repos/o/r/commits/", func(w http.ResponseWriter, r *http.Request) {
+		sha := strings.TrimPrefix(r.URL.Path, "/repos/o/r/commits/")
+		fmt.Fprintf(w, `{"commit": {"message": "%s", "author": {"email": "%s@x", "date": "2024-01-02T00:00:00Z"}, "committer": {"date": "2024-01-02T00:00:00Z"}}, "author": {"login": "u"}, "stats": {"additions": 1, "deletions": 1, "total": 2}, "files": [{"filename": "main.go"}]}`, sha, sha)
+	})
+	mux.HandleFunc("/repos/o/r/pulls", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"number": 1}]`)
+	})
+	mux.HandleFunc("/repos/o/r/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"title": "t", "comments": 2, "commits": 1, "state": "closed", "user": {"login": "u"}, "created_at": "2024-01-01T00:00:00Z", "merged_at": "2024-01-02T00:00:00Z"}`)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Remaining", "4000")
+		w.Header().Set("X-Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	saved := client
+	client = serverClient{srv}
+	t.Cleanup(func() { client = saved })
+
+	return srv
+}
+
+// run f with workers taking from c until it returns and they're through
+func withWorkers(f func(c chan<- func())) {
+	c := make(chan func(), 10)
+	finished := make(chan bool)
+	go func() {
+		for f := range c {
+			f()
+		}
+		finished <- true
+	}()
+	f(c)
+	close(c)
+	<-finished
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	resetListing(t)
+	testGithub(t)
+	cfg := &Config{Orgs: []string{"o"}, Tokens: makeTokens("x", ""), Rate: 1000, RateReserve: 10, PageSize: 100, MaxPages: 10, Limit: 100, MaxAttempts: 5}
+	st := newMemStore()
+
+	// inserter, then updater
+	withWorkers(func(c chan<- func()) {
+		pg.Add(1)
+		repos(ctx, cfg, st, c)
+	})
+	if len(st.commits) != 2 || len(st.pulls) != 1 {
+		t.Fatalf("commits=%v pulls=%v, want 2 and 1 listed", len(st.commits), len(st.pulls))
+	}
+	withWorkers(func(c chan<- func()) {
+		pg.Add(2)
+		queryCommits(ctx, cfg, st, c)
+		queryPulls(ctx, cfg, st, c)
+	})
+
+	for _, c := range st.commits {
+		if c.Meta == nil || c.Meta.Email != c.Sha+"@x" || c.Meta.Total != 2 || *c.Meta.Login != "u" || len(c.Files) != 1 {
+			t.Errorf("commit %v: meta=%+v files=%v, want it filled in", c.Sha, c.Meta, c.Files)
+		}
+	}
+	for _, p := range st.pulls {
+		if p.Meta == nil || p.Meta.Title != "t" || p.Meta.Comments != 2 || p.Meta.MergedAt == nil {
+			t.Errorf("pull %v: meta=%+v, want it filled in", p.Number, p.Meta)
+		}
+	}
+	if pending, _ := st.QueryPendingCommits(ctx, "o", "", 10); len(pending) != 0 {
+		t.Errorf("pending commits=%v, want none", pending)
+	}
+	if pending, _ := st.QueryPendingPulls(ctx, "o", "", 10); len(pending) != 0 {
+		t.Errorf("pending pulls=%v, want none", pending)
+	}
+}
+
+func TestRepoOk(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		ok   bool
+	}{
+		{"prism", true},
+		{"my.repo-1_x", true},
+		{"other/prism", true},
+		{".github", true},
+		{"a..b", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../x", false},
+		{"o/..", false},
+		{"a/b/c", false},
+		{"x'; DROP TABLE commits; --", false},
+		{"x?per_page=1", false},
+		{"x#y", false},
+		{"x%2F..", false},
+	} {
+		if got := repoOk(c.name); got != c.ok {
+			t.Errorf("repoOk(%q)=%v, want %v", c.name, got, c.ok)
+		}
+	}
+}
+
+// --repos names land in each url's path as the repo, and nowhere else
+func TestRepoUrls(t *testing.T) {
+	cfg := &Config{PageSize: 100}
+	for _, u := range []string{
+		commitsUrl(cfg, "o", "my.repo-1", "", "", ""),
+		pullsUrl(cfg, "o", "my.repo-1"),
+		commitUrl("o", "my.repo-1", "abc"),
+		dependabotAlertsUrl(cfg, "o", "my.repo-1"),
+	} {
+		if org, repo := urlRepo(u); org != "o" || repo != "my.repo-1" {
+			t.Errorf("urlRepo(%q)=%v/%v, want o/my.repo-1", u, org, repo)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// commit as held by memStore; nil meta until the updater fills it in
+type memCommit struct {
+	Org     string
+	Repo    string
+	Sha     string
+	Meta    *commitMeta
+	Files   []commitFile
+	Parents []string
+	Checks  []checkRun
+	Checked bool
+	Missing bool
+}
+
+// pull as held by memStore
+type memPull struct {
+	Org    string
+	Repo   string
+	Number int
+	Meta   *pullMeta
+}
+
+// failed lookups of a commit or pull, as held by memStore
+type memDeadLetter struct {
+	Org      string
+	Repo     string
+	Url      string
+	Error    string
+	Attempts int
+	Dead     bool
+}
+
+// backfill window and how far it got, as held by memStore
+type memBackfill struct {
+	Since string
+	Until string
+	Done  string
+}
+
+// Store backed by maps, for tests and --store mem; everything is gone
+// when the process exits. ids count up, zero padded, so they page by id
+// as text like the database's
+type memStore struct {
+	sync.Mutex
+	n           int
+	commits     map[string]*memCommit
+	commitIds   map[string]string
+	pulls       map[string]*memPull
+	pullIds     map[string]string
+	order       []string
+	dependabot  map[string]map[int]dependabotAlert
+	scanning    map[string]map[int]codeScanningAlert
+	runs        map[string]map[int64]workflowRun
+	deploys     map[string]map[int64]deployment
+	statuses    map[string]map[int64]deploymentStatus
+	teams       map[string]string
+	members     map[string][]string
+	languages   map[string]map[string]int
+	topics      map[string][]string
+	deadLetters map[string]*memDeadLetter
+	progress    map[string]state
+	backfills   map[string]memBackfill
+	etags       map[string]map[string]string
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		commits:     make(map[string]*memCommit),
+		commitIds:   make(map[string]string),
+		pulls:       make(map[string]*memPull),
+		pullIds:     make(map[string]string),
+		dependabot:  make(map[string]map[int]dependabotAlert),
+		scanning:    make(map[string]map[int]codeScanningAlert),
+		runs:        make(map[string]map[int64]workflowRun),
+		deploys:     make(map[string]map[int64]deployment),
+		statuses:    make(map[string]map[int64]deploymentStatus),
+		teams:       make(map[string]string),
+		members:     make(map[string][]string),
+		languages:   make(map[string]map[string]int),
+		topics:      make(map[string][]string),
+		deadLetters: make(map[string]*memDeadLetter),
+		progress:    make(map[string]state),
+		backfills:   make(map[string]memBackfill),
+		etags:       make(map[string]map[string]string),
+	}
+}
+
+// next id, in insertion order
+func (s *memStore) id() string {
+	s.n++
+	id := fmt.Sprintf("%012d", s.n)
+	s.order = append(s.order, id)
+
+	return id
+}
+
+// whether the kind row id is dead lettered
+func (s *memStore) dead(kind, id string) bool {
+	d, ok := s.deadLetters[kind+"/"+id]
+	return ok && d.Dead
+}
+
+func (s *memStore) FindOrCreateCommit(ctx context.Context, org, repo, sha string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s", org, repo, sha)
+	if _, ok := s.commitIds[key]; ok {
+		return false, nil
+	}
+	id := s.id()
+	s.commitIds[key] = id
+	s.commits[id] = &memCommit{Org: org, Repo: repo, Sha: sha}
+
+	return true, nil
+}
+
+func (s *memStore) UpdateCommit(ctx context.Context, id string, m commitMeta) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c, ok := s.commits[id]; ok {
+		c.Meta = &m
+	}
+
+	return nil
+}
+
+func (s *memStore) CreateCommitFiles(ctx context.Context, id string, files []commitFile) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c, ok := s.commits[id]; ok {
+		c.Files = append([]commitFile(nil), files...)
+	}
+
+	return nil
+}
+
+func (s *memStore) CreateCommitParents(ctx context.Context, id string, parents []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c, ok := s.commits[id]; ok {
+		c.Parents = append([]string(nil), parents...)
+	}
+
+	return nil
+}
+
+func (s *memStore) MissingCommit(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c, ok := s.commits[id]; ok {
+		c.Missing = true
+	}
+
+	return nil
+}
+
+func (s *memStore) ResetMissingCommits(ctx context.Context, org string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var n int64
+	for _, c := range s.commits {
+		if c.Org == org && c.Missing {
+			c.Missing = false
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// commits of org past after, in id order, that want says are due
+func (s *memStore) queryCommits(org, after string, limit int, want func(id string, c *memCommit) bool) []pendingCommit {
+	s.Lock()
+	defer s.Unlock()
+
+	var pending []pendingCommit
+	for _, id := range s.order {
+		if len(pending) >= limit {
+			break
+		}
+		c, ok := s.commits[id]
+		if !ok || c.Org != org || id <= after || c.Missing || !want(id, c) {
+			continue
+		}
+		pending = append(pending, pendingCommit{Id: id, Repo: c.Repo, Sha: c.Sha})
+	}
+
+	return pending
+}
+
+func (s *memStore) QueryPendingCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	return s.queryCommits(org, after, limit, func(id string, c *memCommit) bool {
+		return c.Meta == nil && !s.dead("commit", id)
+	}), nil
+}
+
+func (s *memStore) QueryUncheckedCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	return s.queryCommits(org, after, limit, func(id string, c *memCommit) bool {
+		return !c.Checked
+	}), nil
+}
+
+func (s *memStore) SaveCheckRuns(ctx context.Context, id string, runs []checkRun) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if c, ok := s.commits[id]; ok {
+		c.Checks, c.Checked = append([]checkRun(nil), runs...), true
+	}
+
+	return nil
+}
+
+func (s *memStore) LatestCommitDate(ctx context.Context, org, repo string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var latest string
+	for _, c := range s.commits {
+		if c.Org == org && c.Repo == repo && c.Meta != nil && c.Meta.CommitterDate > latest {
+			latest = c.Meta.CommitterDate
+		}
+	}
+
+	return latest, nil
+}
+
+func (s *memStore) FindOrCreatePull(ctx context.Context, org, repo string, number int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%d", org, repo, number)
+	if _, ok := s.pullIds[key]; ok {
+		return false, nil
+	}
+	id := s.id()
+	s.pullIds[key] = id
+	s.pulls[id] = &memPull{Org: org, Repo: repo, Number: number}
+
+	return true, nil
+}
+
+func (s *memStore) UpdatePull(ctx context.Context, id string, m pullMeta) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if p, ok := s.pulls[id]; ok {
+		p.Meta = &m
+	}
+
+	return nil
+}
+
+func (s *memStore) FindPull(ctx context.Context, org, repo string, number int) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.pullIds[fmt.Sprintf("%s/%s/%d", org, repo, number)], nil
+}
+
+func (s *memStore) QueryPendingPulls(ctx context.Context, org, after string, limit int) ([]pendingPull, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var pending []pendingPull
+	for _, id := range s.order {
+		if len(pending) >= limit {
+			break
+		}
+		p, ok := s.pulls[id]
+		if !ok || p.Org != org || id <= after || p.Meta != nil || s.dead("pull", id) {
+			continue
+		}
+		pending = append(pending, pendingPull{Id: id, Repo: p.Repo, Number: p.Number})
+	}
+
+	return pending, nil
+}
+
+func (s *memStore) SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.dependabot[org+"/"+repo] == nil {
+		s.dependabot[org+"/"+repo] = make(map[int]dependabotAlert)
+	}
+	s.dependabot[org+"/"+repo][a.Number] = a
+
+	return nil
+}
+
+func (s *memStore) SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.scanning[org+"/"+repo] == nil {
+		s.scanning[org+"/"+repo] = make(map[int]codeScanningAlert)
+	}
+	s.scanning[org+"/"+repo][a.Number] = a
+
+	return nil
+}
+
+func (s *memStore) SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.runs[org+"/"+repo] == nil {
+		s.runs[org+"/"+repo] = make(map[int64]workflowRun)
+	}
+	s.runs[org+"/"+repo][r.RunId] = r
+
+	return nil
+}
+
+func (s *memStore) SaveDeployment(ctx context.Context, org, repo string, d deployment) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.deploys[org+"/"+repo] == nil {
+		s.deploys[org+"/"+repo] = make(map[int64]deployment)
+	}
+	s.deploys[org+"/"+repo][d.DeploymentId] = d
+
+	return nil
+}
+
+func (s *memStore) SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, st deploymentStatus) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.statuses[org+"/"+repo] == nil {
+		s.statuses[org+"/"+repo] = make(map[int64]deploymentStatus)
+	}
+	s.statuses[org+"/"+repo][st.StatusId] = st
+
+	return nil
+}
+
+func (s *memStore) SaveTeam(ctx context.Context, org, slug, name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.teams[org+"/"+slug] = name
+
+	return nil
+}
+
+func (s *memStore) SaveTeamMembers(ctx context.Context, org, slug string, logins []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.members[org+"/"+slug] = append([]string(nil), logins...)
+
+	return nil
+}
+
+func (s *memStore) SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	held := make(map[string]int, len(langs))
+	for lang, bytes := range langs {
+		held[lang] = bytes
+	}
+	s.languages[org+"/"+repo] = held
+
+	return nil
+}
+
+func (s *memStore) SaveRepoTopics(ctx context.Context, org, repo string, topics []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.topics[org+"/"+repo] = append([]string(nil), topics...)
+
+	return nil
+}
+
+func (s *memStore) QueryRepos(ctx context.Context, org string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	seen := make(map[string]bool)
+	for _, c := range s.commits {
+		if c.Org == org {
+			seen[c.Repo] = true
+		}
+	}
+	for _, p := range s.pulls {
+		if p.Org == org {
+			seen[p.Repo] = true
+		}
+	}
+	for _, d := range s.deadLetters {
+		if d.Org == org {
+			seen[d.Repo] = true
+		}
+	}
+	keyed := func(key string) {
+		if strings.HasPrefix(key, org+"/") {
+			seen[strings.TrimPrefix(key, org+"/")] = true
+		}
+	}
+	for key := range s.dependabot {
+		keyed(key)
+	}
+	for key := range s.scanning {
+		keyed(key)
+	}
+	for key := range s.runs {
+		keyed(key)
+	}
+	for key := range s.deploys {
+		keyed(key)
+	}
+	for key := range s.statuses {
+		keyed(key)
+	}
+	for key := range s.languages {
+		keyed(key)
+	}
+	for key := range s.topics {
+		keyed(key)
+	}
+	for key := range s.backfills {
+		keyed(key)
+	}
+	for key := range s.etags {
+		keyed(key)
+	}
+
+	var repos []string
+	for repo := range seen {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	return repos, nil
+}
+
+// drop every row for repo, counted as the database would
+func (s *memStore) DeleteRepo(ctx context.Context, org, repo string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var n int64
+	for id, c := range s.commits {
+		if c.Org == org && c.Repo == repo {
+			delete(s.commits, id)
+			delete(s.commitIds, fmt.Sprintf("%s/%s/%s", org, repo, c.Sha))
+			n++
+		}
+	}
+	for id, p := range s.pulls {
+		if p.Org == org && p.Repo == repo {
+			delete(s.pulls, id)
+			delete(s.pullIds, fmt.Sprintf("%s/%s/%d", org, repo, p.Number))
+			n++
+		}
+	}
+	for key, d := range s.deadLetters {
+		if d.Org == org && d.Repo == repo {
+			delete(s.deadLetters, key)
+			n++
+		}
+	}
+
+	key := org + "/" + repo
+	n += int64(len(s.dependabot[key]) + len(s.scanning[key]) + len(s.runs[key]) + len(s.deploys[key]) + len(s.statuses[key]) + len(s.languages[key]) + len(s.topics[key]) + len(s.etags[key]))
+	if _, ok := s.backfills[key]; ok {
+		n++
+	}
+	delete(s.dependabot, key)
+	delete(s.scanning, key)
+	delete(s.runs, key)
+	delete(s.deploys, key)
+	delete(s.statuses, key)
+	delete(s.languages, key)
+	delete(s.topics, key)
+	delete(s.backfills, key)
+	delete(s.etags, key)
+
+	return n, nil
+}
+
+func (s *memStore) FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	d, ok := s.deadLetters[kind+"/"+id]
+	if !ok {
+		d = &memDeadLetter{Org: org, Repo: repo}
+		s.deadLetters[kind+"/"+id] = d
+	}
+	d.Url, d.Error = url, lastErr
+	d.Attempts++
+	d.Dead = max > 0 && d.Attempts >= max
+
+	return d.Dead, nil
+}
+
+func (s *memStore) RetryDeadLetters(ctx context.Context, org string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var n int64
+	for key, d := range s.deadLetters {
+		if d.Org == org && d.Dead {
+			delete(s.deadLetters, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *memStore) SaveProgress(ctx context.Context, orgs []string, p state) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, org := range orgs {
+		delete(s.progress, org)
+	}
+	if p.Org != "" {
+		s.progress[p.Org] = p
+	}
+
+	return nil
+}
+
+func (s *memStore) LoadProgress(ctx context.Context, orgs []string) (state, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, org := range orgs {
+		if p, ok := s.progress[org]; ok {
+			return p, nil
+		}
+	}
+
+	return state{}, nil
+}
+
+func (s *memStore) SaveBackfill(ctx context.Context, org, repo, since, until, done string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.backfills[org+"/"+repo] = memBackfill{Since: since, Until: until, Done: done}
+
+	return nil
+}
+
+func (s *memStore) LoadBackfill(ctx context.Context, org, repo, since, until string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if b, ok := s.backfills[org+"/"+repo]; ok && b.Since == since && b.Until == until {
+		return b.Done, nil
+	}
+
+	return "", nil
+}
+
+func (s *memStore) SaveEtag(ctx context.Context, url, etag string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	org, repo := urlRepo(url)
+	key := org + "/" + repo
+	if s.etags[key] == nil {
+		s.etags[key] = make(map[string]string)
+	}
+	s.etags[key][url] = etag
+
+	return nil
+}
+
+func (s *memStore) LoadEtag(ctx context.Context, url string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	org, repo := urlRepo(url)
+
+	return s.etags[org+"/"+repo][url], nil
+}
+
+// nothing to migrate
+func (s *memStore) Migrate(ctx context.Context) error {
+	return nil
+}
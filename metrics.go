@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prism_api_requests_total",
+		Help: "GitHub API requests by status code.",
+	}, []string{"code"})
+	apiEndpointRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prism_api_endpoint_requests_total",
+		Help: "GitHub API requests by endpoint, e.g. commits/{id}.",
+	}, []string{"endpoint"})
+	rateLimitPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prism_rate_limit_pauses_total",
+		Help: "Pauses waiting on the rate limit.",
+	})
+	rateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prism_rate_limit_remaining",
+		Help: "Last observed X-RateLimit-Remaining.",
+	})
+	commitsInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prism_commits_inserted_total",
+		Help: "Commits inserted.",
+	})
+	commitsUpdated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prism_commits_updated_total",
+		Help: "Commits updated with metadata.",
+	})
+	pullsInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prism_pulls_inserted_total",
+		Help: "Pulls inserted.",
+	})
+	pullsUpdated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prism_pulls_updated_total",
+		Help: "Pulls updated with metadata.",
+	})
+	tasksInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prism_tasks_in_flight",
+		Help: "Tasks workers are running right now.",
+	})
+)
+
+// which collections take an id or sha after them in a path
+var idAfter = map[string]bool{"commits": true, "pulls": true, "deployments": true, "teams": true, "runs": true}
+
+// api url as the endpoint it's counted against: the path past the org or
+// repo, with ids as {id}, so pulls/{id} rather than every pull
+func endpoint(url string) string {
+	path := strings.SplitN(strings.TrimPrefix(url, "https://api.github.com/"), "?", 2)[0]
+	parts := strings.Split(path, "/")
+
+	var rest []string
+	switch {
+	case parts[0] == "repos" && len(parts) > 3:
+		rest = parts[3:]
+	case (parts[0] == "orgs" || parts[0] == "users") && len(parts) > 2:
+		rest = parts[2:]
+	default:
+		return parts[0]
+	}
+
+	out := make([]string, len(rest))
+	for i, p := range rest {
+		if i > 0 && idAfter[rest[i-1]] {
+			p = "{id}"
+		}
+		out[i] = p
+	}
+
+	return strings.Join(out, "/")
+}
+
+// totals for the end of run summary, kept beside the prometheus
+// counters as those can't be read back
+type runStats struct {
+	start          time.Time
+	repos          int64
+	commitsFound   int64
+	commitsUpdated int64
+	pullsFound     int64
+	pullsUpdated   int64
+	requests       int64
+	pauses         int64
+	remaining      int64
+}
+
+var stats = runStats{start: time.Now()}
+
+// one line to alert on once a run finishes
+func (s *runStats) log() {
+	infof("fn=summary %s\n", s)
+}
+
+func (s *runStats) String() string {
+	return fmt.Sprintf("repos=%v commits_found=%v commits_updated=%v pulls_found=%v pulls_updated=%v requests=%v rate_limit_pauses=%v elapsed=%v",
+		atomic.LoadInt64(&s.repos), atomic.LoadInt64(&s.commitsFound), atomic.LoadInt64(&s.commitsUpdated),
+		atomic.LoadInt64(&s.pullsFound), atomic.LoadInt64(&s.pullsUpdated), atomic.LoadInt64(&s.requests),
+		atomic.LoadInt64(&s.pauses), time.Since(s.start).Round(time.Second))
+}
+
+// repos with tasks running, and how many each
+type repoCounts struct {
+	sync.Mutex
+	n map[string]int
+}
+
+var inFlight = repoCounts{n: map[string]int{}}
+
+func (r *repoCounts) add(repo string, n int) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.n[repo] += n; r.n[repo] <= 0 {
+		delete(r.n, repo)
+	}
+}
+
+func (r *repoCounts) String() string {
+	r.Lock()
+	defer r.Unlock()
+
+	repos := make([]string, 0, len(r.n))
+	for repo, n := range r.n {
+		repos = append(repos, fmt.Sprintf("%s:%d", repo, n))
+	}
+	sort.Strings(repos)
+
+	return strings.Join(repos, ",")
+}
+
+// log a snapshot of the run on SIGUSR1, without stopping it; only reads
+// counters, so it's safe to send as often as you like
+func dumpOnSignal(pools map[string]chan func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	go func() {
+		for range sig {
+			names := make([]string, 0, len(pools))
+			for pool := range pools {
+				names = append(names, pool)
+			}
+			sort.Strings(names)
+
+			queues := make([]string, len(names))
+			for i, pool := range names {
+				queues[i] = fmt.Sprintf("%s:%d", pool, len(pools[pool]))
+			}
+			infof("fn=dump %s rate_limit_remaining=%v queues=%s in_flight=%q\n",
+				&stats, atomic.LoadInt64(&stats.remaining), strings.Join(queues, ","), inFlight.String())
+		}
+	}()
+}
+
+// serve /metrics on --metrics-addr, if set, with the depth of each
+// worker channel by pool
+func serveMetrics(cfg *Config, pools map[string]chan func()) {
+	if cfg.MetricsAddr == "" {
+		return
+	}
+
+	for pool, c := range pools {
+		c := c
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "prism_queue_depth",
+			Help:        "Tasks waiting on a worker channel.",
+			ConstLabels: prometheus.Labels{"pool": pool},
+		}, func() float64 { return float64(len(c)) })
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		infof("fn=serveMetrics addr=%v\n", cfg.MetricsAddr)
+		log.Fatal(http.ListenAndServe(cfg.MetricsAddr, mux))
+	}()
+}
+
+// serve /debug/pprof/ on --pprof-addr, if set; its own mux, so profiles
+// never show up on the metrics or api addresses
+func servePprof(cfg *Config) {
+	if cfg.PprofAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		infof("fn=servePprof addr=%v\n", cfg.PprofAddr)
+		log.Fatal(http.ListenAndServe(cfg.PprofAddr, mux))
+	}()
+}
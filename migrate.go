@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// schemas, applied in file name order; postgres at the top, sqlite below
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrations embed.FS
+
+// apply any of the store's migrations not yet recorded in schema_migrations
+func (s *sqlStore) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, s.q("CREATE TABLE IF NOT EXISTS {prefix}schema_migrations (version text PRIMARY KEY, applied_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP)")); err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(migrations, s.migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		version := strings.TrimSuffix(e.Name(), ".sql")
+
+		var applied bool
+		if err := s.db.QueryRowContext(ctx, s.q("SELECT EXISTS (SELECT 1 FROM {prefix}schema_migrations WHERE version=$1)"), version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		script, err := fs.ReadFile(migrations, s.migrations+"/"+e.Name())
+		if err != nil {
+			return err
+		}
+
+		if err := s.apply(ctx, version, string(script)); err != nil {
+			return fmt.Errorf("migration %v: %v", version, err)
+		}
+		infof("fn=Migrate version=%v\n", version)
+	}
+
+	return nil
+}
+
+// each migration and its version row commit together
+func (s *sqlStore) apply(ctx context.Context, version, script string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}schema_migrations (version) VALUES ($1)"), version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
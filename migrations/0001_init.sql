@@ -0,0 +1,36 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+CREATE EXTENSION IF NOT EXISTS "pg_trgm";
+
+CREATE TABLE IF NOT EXISTS {prefix}commits (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    sha text NOT NULL,
+    msg text,
+    email text,
+    date timestamp with time zone,
+    adds integer,
+    dels integer,
+    total integer
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}commits_on_org_repo_sha ON {prefix}commits USING btree(org, repo, sha);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_email ON {prefix}commits USING btree(email);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_date ON {prefix}commits USING btree(date);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_repo ON {prefix}commits USING btree(repo);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_msg ON {prefix}commits USING gist(msg gist_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS {prefix}pulls (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    title text,
+    comments integer,
+    commits integer,
+    adds integer,
+    dels integer,
+    changed integer
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}pulls_on_org_repo_number ON {prefix}pulls USING btree(org, repo, number);
@@ -0,0 +1 @@
+ALTER TABLE {prefix}commits ADD COLUMN IF NOT EXISTS missing_at timestamp with time zone;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS {prefix}dependabot_alerts (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    package text,
+    severity text,
+    state text,
+    created_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}dependabot_alerts_on_org_repo_number ON {prefix}dependabot_alerts USING btree(org, repo, number);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS {prefix}code_scanning_alerts (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    rule_id text,
+    severity text,
+    state text,
+    tool text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}code_scanning_alerts_on_org_repo_number ON {prefix}code_scanning_alerts USING btree(org, repo, number);
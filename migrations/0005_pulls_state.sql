@@ -0,0 +1,6 @@
+ALTER TABLE {prefix}pulls
+    ADD COLUMN IF NOT EXISTS state text,
+    ADD COLUMN IF NOT EXISTS login text,
+    ADD COLUMN IF NOT EXISTS created_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS merged_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS closed_at timestamp with time zone;
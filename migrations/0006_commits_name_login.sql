@@ -0,0 +1,5 @@
+ALTER TABLE {prefix}commits
+    ADD COLUMN IF NOT EXISTS name text,
+    ADD COLUMN IF NOT EXISTS login text;
+
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_login ON {prefix}commits USING btree(login);
@@ -0,0 +1,5 @@
+ALTER TABLE {prefix}commits
+    ADD COLUMN IF NOT EXISTS committer_email text,
+    ADD COLUMN IF NOT EXISTS committer_date timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_committer_date ON {prefix}commits USING btree(committer_date);
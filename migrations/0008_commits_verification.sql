@@ -0,0 +1,3 @@
+ALTER TABLE {prefix}commits
+    ADD COLUMN IF NOT EXISTS verified boolean,
+    ADD COLUMN IF NOT EXISTS verification_reason text;
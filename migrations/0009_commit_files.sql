@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS {prefix}commit_files (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    commit_id uuid NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    filename text NOT NULL,
+    status text,
+    adds integer,
+    dels integer
+);
+
+CREATE INDEX IF NOT EXISTS {prefix}commit_files_on_commit_id ON {prefix}commit_files USING btree(commit_id);
+CREATE INDEX IF NOT EXISTS {prefix}commit_files_on_filename ON {prefix}commit_files USING btree(filename);
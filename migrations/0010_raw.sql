@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}commits ADD COLUMN IF NOT EXISTS raw jsonb;
+ALTER TABLE {prefix}pulls ADD COLUMN IF NOT EXISTS raw jsonb;
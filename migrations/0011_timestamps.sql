@@ -0,0 +1,11 @@
+ALTER TABLE {prefix}commits
+    ADD COLUMN IF NOT EXISTS inserted_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone;
+ALTER TABLE {prefix}commits ALTER COLUMN inserted_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_updated_at ON {prefix}commits USING btree(updated_at);
+
+ALTER TABLE {prefix}pulls
+    ADD COLUMN IF NOT EXISTS inserted_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone;
+ALTER TABLE {prefix}pulls ALTER COLUMN inserted_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS {prefix}pulls_on_updated_at ON {prefix}pulls USING btree(updated_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}commit_parents (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    commit_id uuid NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    parent_sha text NOT NULL,
+    ordinal integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}commit_parents_on_commit_id_ordinal ON {prefix}commit_parents USING btree(commit_id, ordinal);
+CREATE INDEX IF NOT EXISTS {prefix}commit_parents_on_parent_sha ON {prefix}commit_parents USING btree(parent_sha);
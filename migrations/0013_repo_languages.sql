@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}repo_languages (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    language text NOT NULL,
+    bytes bigint NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}repo_languages_on_org_repo_language ON {prefix}repo_languages USING btree(org, repo, language);
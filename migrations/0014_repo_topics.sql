@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}repo_topics (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    topic text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}repo_topics_on_org_repo_topic ON {prefix}repo_topics USING btree(org, repo, topic);
+CREATE INDEX IF NOT EXISTS {prefix}repo_topics_on_topic ON {prefix}repo_topics USING btree(topic);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS {prefix}workflow_runs (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    run_id bigint NOT NULL,
+    workflow text,
+    event text,
+    status text,
+    conclusion text,
+    head_sha text,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}workflow_runs_on_org_repo_run_id ON {prefix}workflow_runs USING btree(org, repo, run_id);
+CREATE INDEX IF NOT EXISTS {prefix}workflow_runs_on_head_sha ON {prefix}workflow_runs USING btree(head_sha);
+CREATE INDEX IF NOT EXISTS {prefix}workflow_runs_on_created_at ON {prefix}workflow_runs USING btree(created_at);
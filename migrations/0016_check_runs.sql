@@ -0,0 +1,13 @@
+ALTER TABLE {prefix}commits ADD COLUMN IF NOT EXISTS checks_at timestamp with time zone;
+
+CREATE TABLE IF NOT EXISTS {prefix}check_runs (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    commit_id uuid NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    status text,
+    conclusion text,
+    started_at timestamp with time zone,
+    completed_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS {prefix}check_runs_on_commit_id ON {prefix}check_runs USING btree(commit_id);
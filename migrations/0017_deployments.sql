@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS {prefix}deployments (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    deployment_id bigint NOT NULL,
+    environment text,
+    ref text,
+    sha text,
+    creator text,
+    created_at timestamp with time zone,
+    state text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}deployments_on_org_repo_deployment_id ON {prefix}deployments USING btree(org, repo, deployment_id);
+CREATE INDEX IF NOT EXISTS {prefix}deployments_on_created_at ON {prefix}deployments USING btree(created_at);
+
+CREATE TABLE IF NOT EXISTS {prefix}deployment_statuses (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    deployment_id bigint NOT NULL,
+    status_id bigint NOT NULL,
+    state text,
+    creator text,
+    created_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}deployment_statuses_on_org_repo_status_id ON {prefix}deployment_statuses USING btree(org, repo, status_id);
+CREATE INDEX IF NOT EXISTS {prefix}deployment_statuses_on_deployment_id ON {prefix}deployment_statuses USING btree(deployment_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS {prefix}teams (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    slug text NOT NULL,
+    name text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}teams_on_org_slug ON {prefix}teams USING btree(org, slug);
+
+CREATE TABLE IF NOT EXISTS {prefix}team_members (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    slug text NOT NULL,
+    login text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}team_members_on_org_slug_login ON {prefix}team_members USING btree(org, slug, login);
+CREATE INDEX IF NOT EXISTS {prefix}team_members_on_login ON {prefix}team_members USING btree(login);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS {prefix}dead_letters (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    kind text NOT NULL,
+    ref_id text NOT NULL,
+    url text,
+    error text,
+    attempts integer NOT NULL DEFAULT 0,
+    dead_at timestamp with time zone,
+    updated_at timestamp with time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}dead_letters_on_kind_ref_id ON {prefix}dead_letters USING btree(kind, ref_id);
+CREATE INDEX IF NOT EXISTS {prefix}dead_letters_on_org_repo ON {prefix}dead_letters USING btree(org, repo);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}progress (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text,
+    url text,
+    updated_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}progress_on_org ON {prefix}progress USING btree(org);
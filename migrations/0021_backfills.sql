@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS {prefix}backfills (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    since text NOT NULL,
+    until text NOT NULL,
+    done text NOT NULL,
+    updated_at timestamp with time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}backfills_on_org_repo ON {prefix}backfills USING btree(org, repo);
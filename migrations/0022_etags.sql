@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS {prefix}etags (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org text NOT NULL,
+    repo text NOT NULL,
+    url text NOT NULL,
+    etag text NOT NULL,
+    updated_at timestamp with time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}etags_on_url ON {prefix}etags USING btree(url);
@@ -0,0 +1,84 @@
+CREATE TABLE IF NOT EXISTS {prefix}commits (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    sha text NOT NULL,
+    msg text,
+    email text,
+    date timestamp,
+    adds integer,
+    dels integer,
+    total integer,
+    missing_at timestamp,
+    name text,
+    login text,
+    committer_email text,
+    committer_date timestamp,
+    verified boolean,
+    verification_reason text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}commits_on_org_repo_sha ON {prefix}commits(org, repo, sha);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_email ON {prefix}commits(email);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_date ON {prefix}commits(date);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_repo ON {prefix}commits(repo);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_login ON {prefix}commits(login);
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_committer_date ON {prefix}commits(committer_date);
+
+CREATE TABLE IF NOT EXISTS {prefix}commit_files (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    commit_id text NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    filename text NOT NULL,
+    status text,
+    adds integer,
+    dels integer
+);
+
+CREATE INDEX IF NOT EXISTS {prefix}commit_files_on_commit_id ON {prefix}commit_files(commit_id);
+CREATE INDEX IF NOT EXISTS {prefix}commit_files_on_filename ON {prefix}commit_files(filename);
+
+CREATE TABLE IF NOT EXISTS {prefix}pulls (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    title text,
+    comments integer,
+    commits integer,
+    adds integer,
+    dels integer,
+    changed integer,
+    state text,
+    login text,
+    created_at timestamp,
+    merged_at timestamp,
+    closed_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}pulls_on_org_repo_number ON {prefix}pulls(org, repo, number);
+
+CREATE TABLE IF NOT EXISTS {prefix}dependabot_alerts (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    package text,
+    severity text,
+    state text,
+    created_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}dependabot_alerts_on_org_repo_number ON {prefix}dependabot_alerts(org, repo, number);
+
+CREATE TABLE IF NOT EXISTS {prefix}code_scanning_alerts (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    number integer NOT NULL,
+    rule_id text,
+    severity text,
+    state text,
+    tool text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}code_scanning_alerts_on_org_repo_number ON {prefix}code_scanning_alerts(org, repo, number);
@@ -0,0 +1,2 @@
+ALTER TABLE {prefix}commits ADD COLUMN raw text;
+ALTER TABLE {prefix}pulls ADD COLUMN raw text;
@@ -0,0 +1,7 @@
+ALTER TABLE {prefix}commits ADD COLUMN inserted_at timestamp;
+ALTER TABLE {prefix}commits ADD COLUMN updated_at timestamp;
+CREATE INDEX IF NOT EXISTS {prefix}commits_on_updated_at ON {prefix}commits(updated_at);
+
+ALTER TABLE {prefix}pulls ADD COLUMN inserted_at timestamp;
+ALTER TABLE {prefix}pulls ADD COLUMN updated_at timestamp;
+CREATE INDEX IF NOT EXISTS {prefix}pulls_on_updated_at ON {prefix}pulls(updated_at);
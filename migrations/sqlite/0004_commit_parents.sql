@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}commit_parents (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    commit_id text NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    parent_sha text NOT NULL,
+    ordinal integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}commit_parents_on_commit_id_ordinal ON {prefix}commit_parents(commit_id, ordinal);
+CREATE INDEX IF NOT EXISTS {prefix}commit_parents_on_parent_sha ON {prefix}commit_parents(parent_sha);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}repo_languages (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    language text NOT NULL,
+    bytes integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}repo_languages_on_org_repo_language ON {prefix}repo_languages(org, repo, language);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}repo_topics (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    topic text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}repo_topics_on_org_repo_topic ON {prefix}repo_topics(org, repo, topic);
+CREATE INDEX IF NOT EXISTS {prefix}repo_topics_on_topic ON {prefix}repo_topics(topic);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS {prefix}workflow_runs (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    run_id integer NOT NULL,
+    workflow text,
+    event text,
+    status text,
+    conclusion text,
+    head_sha text,
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}workflow_runs_on_org_repo_run_id ON {prefix}workflow_runs(org, repo, run_id);
+CREATE INDEX IF NOT EXISTS {prefix}workflow_runs_on_head_sha ON {prefix}workflow_runs(head_sha);
+CREATE INDEX IF NOT EXISTS {prefix}workflow_runs_on_created_at ON {prefix}workflow_runs(created_at);
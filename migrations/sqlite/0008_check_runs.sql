@@ -0,0 +1,13 @@
+ALTER TABLE {prefix}commits ADD COLUMN checks_at timestamp;
+
+CREATE TABLE IF NOT EXISTS {prefix}check_runs (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    commit_id text NOT NULL REFERENCES {prefix}commits(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    status text,
+    conclusion text,
+    started_at timestamp,
+    completed_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS {prefix}check_runs_on_commit_id ON {prefix}check_runs(commit_id);
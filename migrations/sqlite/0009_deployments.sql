@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS {prefix}deployments (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    deployment_id integer NOT NULL,
+    environment text,
+    ref text,
+    sha text,
+    creator text,
+    created_at timestamp,
+    state text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}deployments_on_org_repo_deployment_id ON {prefix}deployments(org, repo, deployment_id);
+CREATE INDEX IF NOT EXISTS {prefix}deployments_on_created_at ON {prefix}deployments(created_at);
+
+CREATE TABLE IF NOT EXISTS {prefix}deployment_statuses (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    deployment_id integer NOT NULL,
+    status_id integer NOT NULL,
+    state text,
+    creator text,
+    created_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}deployment_statuses_on_org_repo_status_id ON {prefix}deployment_statuses(org, repo, status_id);
+CREATE INDEX IF NOT EXISTS {prefix}deployment_statuses_on_deployment_id ON {prefix}deployment_statuses(deployment_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS {prefix}teams (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    slug text NOT NULL,
+    name text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}teams_on_org_slug ON {prefix}teams(org, slug);
+
+CREATE TABLE IF NOT EXISTS {prefix}team_members (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    slug text NOT NULL,
+    login text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}team_members_on_org_slug_login ON {prefix}team_members(org, slug, login);
+CREATE INDEX IF NOT EXISTS {prefix}team_members_on_login ON {prefix}team_members(login);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS {prefix}dead_letters (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    kind text NOT NULL,
+    ref_id text NOT NULL,
+    url text,
+    error text,
+    attempts integer NOT NULL DEFAULT 0,
+    dead_at timestamp,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}dead_letters_on_kind_ref_id ON {prefix}dead_letters(kind, ref_id);
+CREATE INDEX IF NOT EXISTS {prefix}dead_letters_on_org_repo ON {prefix}dead_letters(org, repo);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {prefix}progress (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text,
+    url text,
+    updated_at timestamp
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}progress_on_org ON {prefix}progress(org);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS {prefix}backfills (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    since text NOT NULL,
+    until text NOT NULL,
+    done text NOT NULL,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}backfills_on_org_repo ON {prefix}backfills(org, repo);
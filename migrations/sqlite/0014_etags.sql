@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS {prefix}etags (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+    org text NOT NULL,
+    repo text NOT NULL,
+    url text NOT NULL,
+    etag text NOT NULL,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {prefix}etags_on_url ON {prefix}etags(url);
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// committer's totals for --report committers
+type committer struct {
+	Org       string `json:"org"`
+	Email     string `json:"email"`
+	Commits   int    `json:"commits"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+// run a --report from what's stored, without touching the github api;
+// a table on stdout, or json lines with --output json; csv goes to --out
+func report(ctx context.Context, cfg *Config, st *sqlStore, name string) {
+	if name == "csv" {
+		csvReport(ctx, cfg, st)
+		return
+	}
+	if name != "committers" {
+		log.Fatalf("unknown report %q", name)
+	}
+
+	var rows []committer
+	for _, org := range cfg.Orgs {
+		cs, err := st.topCommitters(ctx, cfg, org, cfg.Top)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows = append(rows, cs...)
+	}
+
+	if cfg.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range rows {
+			if err := enc.Encode(r); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tEMAIL\tCOMMITS\tADDS\tDELS\t")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t\n", r.Org, r.Email, r.Commits, r.Additions, r.Deletions)
+	}
+	w.Flush()
+}
+
+// where clause for an org's looked up commits, within --since and --until;
+// pending shas have no email yet and --forget blanks it, so neither counts
+func reportWhere(cfg *Config, org string) (string, []interface{}) {
+	where, args := "org=$1 AND email <> ''", []interface{}{org}
+	if cfg.Since != "" {
+		args = append(args, cfg.Since)
+		where += fmt.Sprintf(" AND date >= $%d", len(args))
+	}
+	if cfg.Until != "" {
+		args = append(args, cfg.Until)
+		where += fmt.Sprintf(" AND date <= $%d", len(args))
+	}
+
+	return where, args
+}
+
+// committers with the most commits, within --since and --until
+func (s *sqlStore) topCommitters(ctx context.Context, cfg *Config, org string, n int) ([]committer, error) {
+	where, args := reportWhere(cfg, org)
+	args = append(args, n)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(s.q("SELECT email, count(*) AS n, COALESCE(sum(adds), 0), COALESCE(sum(dels), 0) FROM {prefix}commits WHERE %s GROUP BY email ORDER BY n DESC, email LIMIT $%d"), where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cs []committer
+	for rows.Next() {
+		c := committer{Org: org}
+		if err := rows.Scan(&c.Email, &c.Commits, &c.Additions, &c.Deletions); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+
+	return cs, rows.Err()
+}
+
+// each author's commits per repo as csv on --out, written a row at a
+// time so big orgs aren't held in memory
+func csvReport(ctx context.Context, cfg *Config, st *sqlStore) {
+	out := os.Stdout
+	if cfg.Out != "" {
+		f, err := os.Create(cfg.Out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"org", "email", "repo", "commits", "additions", "deletions"})
+	for _, org := range cfg.Orgs {
+		if err := st.authorRepos(ctx, cfg, org, w); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if w.Flush(); w.Error() != nil {
+		log.Fatal(w.Error())
+	}
+}
+
+func (s *sqlStore) authorRepos(ctx context.Context, cfg *Config, org string, w *csv.Writer) error {
+	where, args := reportWhere(cfg, org)
+	rows, err := s.db.QueryContext(ctx, s.q("SELECT email, repo, count(*), COALESCE(sum(adds), 0), COALESCE(sum(dels), 0) FROM {prefix}commits WHERE ")+where+" GROUP BY email, repo ORDER BY email, repo", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email, repo string
+		var commits, adds, dels int
+		if err := rows.Scan(&email, &repo, &commits, &adds, &dels); err != nil {
+			return err
+		}
+		if err := w.Write([]string{org, email, repo, strconv.Itoa(commits), strconv.Itoa(adds), strconv.Itoa(dels)}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
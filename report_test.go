@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// forgotten users keep their shas but drop out of the reports
+func TestReportForgotten(t *testing.T) {
+	ctx := context.Background()
+	s := testSqlStore(t)
+	for _, c := range []struct{ sha, email string }{{"a", "x@example.com"}, {"b", "y@example.com"}, {"c", "y@example.com"}, {"d", ""}} {
+		if _, err := s.FindOrCreateCommit(ctx, "o", "r", c.sha); err != nil {
+			t.Fatal(err)
+		}
+		if c.email == "" {
+			continue // still pending
+		}
+		var id string
+		if err := s.db.QueryRowContext(ctx, "SELECT id FROM commits WHERE sha=$1", c.sha).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UpdateCommit(ctx, id, commitMeta{Email: c.email, Date: "2024-01-01T00:00:00Z", CommitterEmail: c.email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := s.Forget(ctx, "y@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := s.topCommitters(ctx, &Config{}, "o", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || cs[0].Email != "x@example.com" || cs[0].Commits != 1 {
+		t.Errorf("committers=%+v, want just x@example.com", cs)
+	}
+}
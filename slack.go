@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// posts to a slack incoming webhook; best effort, failures are only logged
+type slackNotifier struct {
+	url string
+}
+
+// nil, which posts nothing, without --slack-webhook
+var slack *slackNotifier
+
+func newSlackNotifier(cfg *Config) *slackNotifier {
+	if cfg.SlackWebhook == "" {
+		return nil
+	}
+
+	return &slackNotifier{url: cfg.SlackWebhook}
+}
+
+func (s *slackNotifier) post(text string) {
+	if s == nil {
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{"text": text})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		warnf("fn=slack err=%v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		warnf("fn=slack err=%v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		warnf("fn=slack status=%v\n", resp.StatusCode)
+	}
+}
+
+// passes log lines through, posting those without a level=, which only
+// log.Fatal writes, before it exits
+type slackWriter struct {
+	w io.Writer
+	s *slackNotifier
+}
+
+func (s slackWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if !bytes.Contains(p, []byte(" level=")) {
+		s.s.post("prism failed: " + strings.TrimSpace(string(p)))
+	}
+
+	return n, err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlite:path/to/prism.db, for local runs without postgres
+func openSqliteStore(url string) *sqlStore {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, "sqlite:"), "//")
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// sqlite takes one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("cannot open database: %v", err)
+	}
+
+	return &sqlStore{db: db, migrations: "migrations/sqlite", idType: "text"}
+}
+
+// postgres, or sqlite when the url says so
+func openStore(cfg *Config, url string) (s *sqlStore) {
+	if strings.HasPrefix(url, "sqlite:") {
+		s = openSqliteStore(url)
+	} else {
+		s = openPgStore(cfg, url)
+	}
+	s.prefix = checkPrefix(cfg.TablePrefix)
+
+	return
+}
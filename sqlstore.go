@@ -0,0 +1,727 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Store backed by database/sql; the SQL sticks to what postgres and sqlite
+// both understand, with each keeping its own schema migrations
+type sqlStore struct {
+	db         *sql.DB
+	migrations string
+	prefix     string
+	// lowest id, where keyset pages start
+	firstId string
+	// column type of ids, for casting text refs back to them
+	idType string
+}
+
+// fill in the table prefix for {prefix}commits and the like, and the id
+// type for CAST(x AS {idtype})
+func (s *sqlStore) q(query string) string {
+	return strings.NewReplacer("{prefix}", s.prefix, "{idtype}", s.idType).Replace(query)
+}
+
+// table prefixes end up in sql as is, so only plain identifiers will do
+var prefixRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// postgres cuts identifiers at 63 bytes, and the longest index name is 41
+// without a prefix; past this, truncated index names could collide
+const maxPrefix = 20
+
+func checkPrefix(prefix string) string {
+	if err := prefixErr(prefix); err != nil {
+		log.Fatal(err)
+	}
+
+	return prefix
+}
+
+// why prefix can't go into table names, or nil if it can
+func prefixErr(prefix string) error {
+	if !prefixRe.MatchString(prefix) {
+		return fmt.Errorf("bad table prefix %q: letters, digits, and underscores only", prefix)
+	}
+	if len(prefix) > maxPrefix {
+		return fmt.Errorf("bad table prefix %q: at most %v characters", prefix, maxPrefix)
+	}
+
+	return nil
+}
+
+func openPgStore(cfg *Config, url string) *sqlStore {
+	return &sqlStore{db: dbOpen(cfg, url), migrations: "migrations", firstId: "00000000-0000-0000-0000-000000000000", idType: "uuid"}
+}
+
+// page after id, compared on its own type so the primary key index applies
+func (s *sqlStore) after(id string) string {
+	if id == "" {
+		return s.firstId
+	}
+
+	return id
+}
+
+// check if sha already there, or insert it
+func (s *sqlStore) FindOrCreateCommit(ctx context.Context, org, repo, sha string) (bool, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}commits WHERE org=$1 AND repo=$2 AND sha=$3"), org, repo, sha).Scan(&id)
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}commits (org, repo, sha, inserted_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP)"), org, repo, sha); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// add metadata to sha
+func (s *sqlStore) UpdateCommit(ctx context.Context, id string, m commitMeta) error {
+	_, err := s.db.ExecContext(ctx, s.q("UPDATE {prefix}commits SET email=$2, date=$3, msg=$4, adds=$5, dels=$6, total=$7, name=$8, login=$9, committer_email=$10, committer_date=$11, verified=$12, verification_reason=$13, raw=$14, updated_at=CURRENT_TIMESTAMP WHERE id=$1"),
+		id, m.Email, m.Date, m.Message, m.Additions, m.Deletions, m.Total, m.Name, m.Login, m.CommitterEmail, m.CommitterDate, m.Verified, m.VerificationReason, rawArg(m.Raw))
+
+	return err
+}
+
+// replace the files of sha, inserted in batches as big commits can touch
+// hundreds of files
+func (s *sqlStore) CreateCommitFiles(ctx context.Context, id string, files []commitFile) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}commit_files WHERE commit_id=$1"), id); err != nil {
+		return err
+	}
+
+	const batch = 500
+	for len(files) > 0 {
+		n := len(files)
+		if n > batch {
+			n = batch
+		}
+
+		values := make([]string, n)
+		args := []interface{}{id}
+		for i, f := range files[:n] {
+			values[i] = fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", len(args)+1, len(args)+2, len(args)+3, len(args)+4)
+			args = append(args, f.Filename, f.Status, f.Additions, f.Deletions)
+		}
+
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}commit_files (commit_id, filename, status, adds, dels) VALUES ")+strings.Join(values, ", "), args...); err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+
+	return tx.Commit()
+}
+
+// replace the parents of sha; two or more make it a merge
+func (s *sqlStore) CreateCommitParents(ctx context.Context, id string, parents []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}commit_parents WHERE commit_id=$1"), id); err != nil {
+		return err
+	}
+	for i, sha := range parents {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}commit_parents (commit_id, parent_sha, ordinal) VALUES ($1, $2, $3)"), id, sha, i); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// raw json as text, which jsonb accepts where it wouldn't bytes; NULL
+// when not kept
+func rawArg(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	return string(raw)
+}
+
+// mark sha as missing so it's no longer queried
+func (s *sqlStore) MissingCommit(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.q("UPDATE {prefix}commits SET missing_at=CURRENT_TIMESTAMP, updated_at=CURRENT_TIMESTAMP WHERE id=$1"), id)
+
+	return err
+}
+
+// clear missing shas so they're queried again
+func (s *sqlStore) ResetMissingCommits(ctx context.Context, org string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.q("UPDATE {prefix}commits SET missing_at=NULL WHERE org=$1 AND missing_at IS NOT NULL"), org)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// find shas that need metadata, the next page by id; a keyset cursor past
+// after, with the first page starting past the lowest id, skipping shas
+// dead lettered for good
+func (s *sqlStore) QueryPendingCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	rows, err := s.db.QueryContext(ctx, s.q("SELECT id, repo, sha FROM {prefix}commits WHERE org=$1 AND email IS NULL AND missing_at IS NULL AND id > $2 AND NOT EXISTS (SELECT 1 FROM {prefix}dead_letters d WHERE d.kind='commit' AND d.dead_at IS NOT NULL AND CAST(d.ref_id AS {idtype})={prefix}commits.id) ORDER BY id LIMIT $3"), org, s.after(after), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingCommit
+	for rows.Next() {
+		var p pendingCommit
+		if err := rows.Scan(&p.Id, &p.Repo, &p.Sha); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// scrub email from every commit, as author or committer, leaving the
+// shas themselves; the email is blanked rather than nulled so the updater
+// doesn't look the commits up again
+func (s *sqlStore) Forget(ctx context.Context, email string) (authored, committed int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.q("UPDATE {prefix}commits SET email='', name=NULL, login=NULL, raw=NULL, updated_at=CURRENT_TIMESTAMP WHERE lower(email)=lower($1)"), email)
+	if err != nil {
+		return 0, 0, err
+	}
+	if authored, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	result, err = tx.ExecContext(ctx, s.q("UPDATE {prefix}commits SET committer_email='', raw=NULL, updated_at=CURRENT_TIMESTAMP WHERE lower(committer_email)=lower($1)"), email)
+	if err != nil {
+		return 0, 0, err
+	}
+	if committed, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	return authored, committed, tx.Commit()
+}
+
+// find shas whose check runs haven't been looked up, the next page by id
+func (s *sqlStore) QueryUncheckedCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error) {
+	rows, err := s.db.QueryContext(ctx, s.q("SELECT id, repo, sha FROM {prefix}commits WHERE org=$1 AND checks_at IS NULL AND missing_at IS NULL AND id > $2 ORDER BY id LIMIT $3"), org, s.after(after), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingCommit
+	for rows.Next() {
+		var p pendingCommit
+		if err := rows.Scan(&p.Id, &p.Repo, &p.Sha); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// replace the check runs of sha, marking it checked even with none
+func (s *sqlStore) SaveCheckRuns(ctx context.Context, id string, runs []checkRun) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}check_runs WHERE commit_id=$1"), id); err != nil {
+		return err
+	}
+	for _, r := range runs {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}check_runs (commit_id, name, status, conclusion, started_at, completed_at) VALUES ($1, $2, $3, $4, $5, $6)"),
+			id, r.Name, r.Status, r.Conclusion, r.StartedAt, r.CompletedAt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.q("UPDATE {prefix}commits SET checks_at=CURRENT_TIMESTAMP WHERE id=$1"), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// newest committer date of repo's shas, as iso8601; empty when none have
+// metadata yet
+func (s *sqlStore) LatestCommitDate(ctx context.Context, org, repo string) (string, error) {
+	var date sql.NullString
+	if err := s.db.QueryRowContext(ctx, s.q("SELECT max(committer_date) FROM {prefix}commits WHERE org=$1 AND repo=$2"), org, repo).Scan(&date); err != nil || !date.Valid {
+		return "", err
+	}
+
+	// postgres hands back a time, sqlite the text it was given
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, date.String); err == nil {
+			return t.UTC().Format(iso8601), nil
+		}
+	}
+
+	return "", fmt.Errorf("unexpected commit date %q", date.String)
+}
+
+// check if pull already there, or insert it
+func (s *sqlStore) FindOrCreatePull(ctx context.Context, org, repo string, number int) (bool, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}pulls WHERE org=$1 AND repo=$2 AND number=$3"), org, repo, number).Scan(&id)
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}pulls (org, repo, number, inserted_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP)"), org, repo, number); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *sqlStore) FindPull(ctx context.Context, org, repo string, number int) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}pulls WHERE org=$1 AND repo=$2 AND number=$3"), org, repo, number).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return id, err
+}
+
+// add metadata to pull; refreshing one already filled in, as webhooks do,
+// leaves the row alone unless its title, stats, or state changed
+func (s *sqlStore) UpdatePull(ctx context.Context, id string, m pullMeta) error {
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE {prefix}pulls SET title=$2, comments=$3, commits=$4, adds=$5, dels=$6, changed=$7, state=$8, login=$9, created_at=$10, merged_at=$11, closed_at=$12, raw=$13, updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND (title IS NULL OR title IS DISTINCT FROM $2 OR comments IS DISTINCT FROM $3 OR commits IS DISTINCT FROM $4 OR adds IS DISTINCT FROM $5 OR dels IS DISTINCT FROM $6 OR changed IS DISTINCT FROM $7
+		OR state IS DISTINCT FROM $8 OR merged_at IS DISTINCT FROM $11 OR closed_at IS DISTINCT FROM $12)`),
+		id, m.Title, m.Comments, m.Commits, m.Additions, m.Deletions, m.Changed, m.State, m.Login, m.CreatedAt, m.MergedAt, m.ClosedAt, rawArg(m.Raw))
+
+	return err
+}
+
+// find pulls that need metadata, the next page by id, skipping pulls dead
+// lettered for good
+func (s *sqlStore) QueryPendingPulls(ctx context.Context, org, after string, limit int) ([]pendingPull, error) {
+	rows, err := s.db.QueryContext(ctx, s.q("SELECT id, repo, number FROM {prefix}pulls WHERE org=$1 AND title IS NULL AND id > $2 AND NOT EXISTS (SELECT 1 FROM {prefix}dead_letters d WHERE d.kind='pull' AND d.dead_at IS NOT NULL AND CAST(d.ref_id AS {idtype})={prefix}pulls.id) ORDER BY id LIMIT $3"), org, s.after(after), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingPull
+	for rows.Next() {
+		var p pendingPull
+		if err := rows.Scan(&p.Id, &p.Repo, &p.Number); err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// check if alert already there, update it, or insert it
+func (s *sqlStore) SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}dependabot_alerts WHERE org=$1 AND repo=$2 AND number=$3"), org, repo, a.Number).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}dependabot_alerts (org, repo, number, package, severity, state, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"), org, repo, a.Number, a.Package, a.Severity, a.State, a.CreatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}dependabot_alerts SET package=$2, severity=$3, state=$4, created_at=$5 WHERE id=$1"), id, a.Package, a.Severity, a.State, a.CreatedAt)
+
+	return err
+}
+
+// check if alert already there, update it, or insert it
+func (s *sqlStore) SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}code_scanning_alerts WHERE org=$1 AND repo=$2 AND number=$3"), org, repo, a.Number).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}code_scanning_alerts (org, repo, number, rule_id, severity, state, tool) VALUES ($1, $2, $3, $4, $5, $6, $7)"), org, repo, a.Number, a.RuleId, a.Severity, a.State, a.Tool)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}code_scanning_alerts SET rule_id=$2, severity=$3, state=$4, tool=$5 WHERE id=$1"), id, a.RuleId, a.Severity, a.State, a.Tool)
+
+	return err
+}
+
+// add or refresh a run; its id is github's, unique within the repo
+func (s *sqlStore) SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}workflow_runs WHERE org=$1 AND repo=$2 AND run_id=$3"), org, repo, r.RunId).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}workflow_runs (org, repo, run_id, workflow, event, status, conclusion, head_sha, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"),
+			org, repo, r.RunId, r.Workflow, r.Event, r.Status, r.Conclusion, r.HeadSha, r.CreatedAt, r.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}workflow_runs SET workflow=$2, event=$3, status=$4, conclusion=$5, head_sha=$6, created_at=$7, updated_at=$8 WHERE id=$1"),
+		id, r.Workflow, r.Event, r.Status, r.Conclusion, r.HeadSha, r.CreatedAt, r.UpdatedAt)
+
+	return err
+}
+
+// add or refresh a deployment
+func (s *sqlStore) SaveDeployment(ctx context.Context, org, repo string, d deployment) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}deployments WHERE org=$1 AND repo=$2 AND deployment_id=$3"), org, repo, d.DeploymentId).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}deployments (org, repo, deployment_id, environment, ref, sha, creator, created_at, state) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"),
+			org, repo, d.DeploymentId, d.Environment, d.Ref, d.Sha, d.Creator, d.CreatedAt, d.State)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}deployments SET environment=$2, ref=$3, sha=$4, creator=$5, created_at=$6, state=$7 WHERE id=$1"),
+		id, d.Environment, d.Ref, d.Sha, d.Creator, d.CreatedAt, d.State)
+
+	return err
+}
+
+// add a deployment's status; statuses don't change once made
+func (s *sqlStore) SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, st deploymentStatus) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}deployment_statuses WHERE org=$1 AND repo=$2 AND status_id=$3"), org, repo, st.StatusId).Scan(&id)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}deployment_statuses (org, repo, deployment_id, status_id, state, creator, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"),
+		org, repo, deploymentId, st.StatusId, st.State, st.Creator, st.CreatedAt)
+
+	return err
+}
+
+// add or rename a team
+func (s *sqlStore) SaveTeam(ctx context.Context, org, slug, name string) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}teams WHERE org=$1 AND slug=$2"), org, slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}teams (org, slug, name) VALUES ($1, $2, $3)"), org, slug, name)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}teams SET name=$2 WHERE id=$1"), id, name)
+
+	return err
+}
+
+// replace the members of team slug
+func (s *sqlStore) SaveTeamMembers(ctx context.Context, org, slug string, logins []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}team_members WHERE org=$1 AND slug=$2"), org, slug); err != nil {
+		return err
+	}
+	for _, login := range logins {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}team_members (org, slug, login) VALUES ($1, $2, $3)"), org, slug, login); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// replace repo's topics
+func (s *sqlStore) SaveRepoTopics(ctx context.Context, org, repo string, topics []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}repo_topics WHERE org=$1 AND repo=$2"), org, repo); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}repo_topics (org, repo, topic) VALUES ($1, $2, $3)"), org, repo, topic); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// replace repo's languages, dropping any github no longer reports
+func (s *sqlStore) SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}repo_languages WHERE org=$1 AND repo=$2"), org, repo); err != nil {
+		return err
+	}
+	for lang, bytes := range langs {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}repo_languages (org, repo, language, bytes) VALUES ($1, $2, $3, $4)"), org, repo, lang, bytes); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// tables with rows per repo, all dropped by DeleteRepo
+var repoTables = []string{"commits", "pulls", "dependabot_alerts", "code_scanning_alerts", "repo_languages", "repo_topics", "workflow_runs", "deployments", "deployment_statuses", "dead_letters", "backfills", "etags"}
+
+// repos with rows in any table, so --deleter sees everything it could delete
+func (s *sqlStore) QueryRepos(ctx context.Context, org string) ([]string, error) {
+	var selects []string
+	for _, table := range repoTables {
+		selects = append(selects, "SELECT repo FROM {prefix}"+table+" WHERE org=$1")
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(strings.Join(selects, " UNION ")+" ORDER BY repo"), org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var repos []string
+	for rows.Next() {
+		var repo string
+		if err := rows.Scan(&repo); err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos, rows.Err()
+}
+
+// delete a repo from every table, all or nothing; commit files go
+// with their commits
+func (s *sqlStore) DeleteRepo(ctx context.Context, org, repo string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var n int64
+	for _, table := range repoTables {
+		result, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}"+table+" WHERE org=$1 AND repo=$2"), org, repo)
+		if err != nil {
+			return 0, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		n += rows
+	}
+
+	return n, tx.Commit()
+}
+
+// count a failed lookup of the kind row id, dead lettered once attempts
+// reach max so the pending queries pass it over
+func (s *sqlStore) FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (bool, error) {
+	var attempts int
+	err := s.db.QueryRowContext(ctx, s.q("SELECT attempts FROM {prefix}dead_letters WHERE kind=$1 AND ref_id=$2"), kind, id).Scan(&attempts)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	attempts++
+	dead := max > 0 && attempts >= max
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}dead_letters (org, repo, kind, ref_id, url, error, attempts, dead_at) VALUES ($1, $2, $3, $4, $5, $6, 1, CASE WHEN $7 THEN CURRENT_TIMESTAMP END)"),
+			org, repo, kind, id, url, lastErr, dead)
+		return dead, err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}dead_letters SET url=$3, error=$4, attempts=$5, dead_at=CASE WHEN $6 THEN COALESCE(dead_at, CURRENT_TIMESTAMP) END, updated_at=CURRENT_TIMESTAMP WHERE kind=$1 AND ref_id=$2"),
+		kind, id, url, lastErr, attempts, dead)
+
+	return dead, err
+}
+
+// drop org's dead letters, attempts and all, so the updater looks them
+// up again
+func (s *sqlStore) RetryDeadLetters(ctx context.Context, org string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.q("DELETE FROM {prefix}dead_letters WHERE org=$1 AND dead_at IS NOT NULL"), org)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// replace the repo listing cursor of orgs; other orgs' cursors are left
+// for whatever run harvests them
+func (s *sqlStore) SaveProgress(ctx context.Context, orgs []string, p state) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, org := range orgs {
+		if _, err := tx.ExecContext(ctx, s.q("DELETE FROM {prefix}progress WHERE org=$1"), org); err != nil {
+			return err
+		}
+	}
+	if p.Org != "" {
+		if _, err := tx.ExecContext(ctx, s.q("INSERT INTO {prefix}progress (org, repo, url, updated_at) VALUES ($1, $2, $3, $4)"), p.Org, p.Repo, p.Url, p.Updated); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) LoadProgress(ctx context.Context, orgs []string) (state, error) {
+	for _, org := range orgs {
+		p := state{Org: org}
+		err := s.db.QueryRowContext(ctx, s.q("SELECT repo, url, updated_at FROM {prefix}progress WHERE org=$1"), org).Scan(&p.Repo, &p.Url, &p.Updated)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		return p, err
+	}
+
+	return state{}, nil
+}
+
+// check if repo's backfill is there, update it, or insert it; a new
+// window replaces the old one
+func (s *sqlStore) SaveBackfill(ctx context.Context, org, repo, since, until, done string) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}backfills WHERE org=$1 AND repo=$2"), org, repo).Scan(&id)
+	if err == sql.ErrNoRows {
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}backfills (org, repo, since, until, done) VALUES ($1, $2, $3, $4, $5)"), org, repo, since, until, done)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}backfills SET since=$2, until=$3, done=$4, updated_at=CURRENT_TIMESTAMP WHERE id=$1"), id, since, until, done)
+
+	return err
+}
+
+func (s *sqlStore) LoadBackfill(ctx context.Context, org, repo, since, until string) (string, error) {
+	var done string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT done FROM {prefix}backfills WHERE org=$1 AND repo=$2 AND since=$3 AND until=$4"), org, repo, since, until).Scan(&done)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return done, err
+}
+
+func (s *sqlStore) SaveEtag(ctx context.Context, url, etag string) error {
+	var id string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT id FROM {prefix}etags WHERE url=$1"), url).Scan(&id)
+	if err == sql.ErrNoRows {
+		org, repo := urlRepo(url)
+		_, err = s.db.ExecContext(ctx, s.q("INSERT INTO {prefix}etags (org, repo, url, etag) VALUES ($1, $2, $3, $4)"), org, repo, url, etag)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.q("UPDATE {prefix}etags SET etag=$2, updated_at=CURRENT_TIMESTAMP WHERE id=$1"), id, etag)
+
+	return err
+}
+
+func (s *sqlStore) LoadEtag(ctx context.Context, url string) (string, error) {
+	var etag string
+	err := s.db.QueryRowContext(ctx, s.q("SELECT etag FROM {prefix}etags WHERE url=$1"), url).Scan(&etag)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return etag, err
+}
+
+func dbOpen(cfg *Config, url string) (db *sql.DB) {
+	name, err := pq.ParseURL(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// url's own sslmode wins over the flag
+	if !strings.Contains(name, "sslmode=") {
+		name += " sslmode=" + cfg.SSLMode
+	}
+
+	db, err = sql.Open("postgres", name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// each worker can hold a query's rows open while it inserts
+	open, idle := cfg.DBMaxOpen, cfg.DBMaxIdle
+	if open == 0 {
+		open = 2 * poolSize(cfg)
+	}
+	if idle == 0 {
+		idle = open
+	}
+	db.SetMaxOpenConns(open)
+	db.SetMaxIdleConns(idle)
+	db.SetConnMaxLifetime(cfg.DBConnLifetime)
+
+	// sql.Open doesn't connect... fail now rather than deep in a worker
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		log.Fatalf("cannot connect to database: %v", err)
+	}
+
+	return
+}
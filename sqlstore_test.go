@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// a migrated sqlite store in the test's temp dir
+func testSqlStore(t *testing.T) *sqlStore {
+	s := openStore(&Config{}, "sqlite:"+t.TempDir()+"/prism.db")
+	t.Cleanup(func() { s.db.Close() })
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestUpdatePullUnchanged(t *testing.T) {
+	ctx := context.Background()
+	s := testSqlStore(t)
+	if _, err := s.FindOrCreatePull(ctx, "o", "r", 1); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.FindPull(ctx, "o", "r", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := func() string {
+		var raw sql.NullString
+		if err := s.db.QueryRowContext(ctx, "SELECT raw FROM pulls WHERE id=$1", id).Scan(&raw); err != nil {
+			t.Fatal(err)
+		}
+		return raw.String
+	}
+
+	m := pullMeta{Title: "t", Comments: 1, State: "open", Login: "u", CreatedAt: "2024-01-01T00:00:00Z", Raw: []byte(`{"n":1}`)}
+	if err := s.UpdatePull(ctx, id, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := raw(); got != `{"n":1}` {
+		t.Fatalf("raw=%q, want the pending pull written", got)
+	}
+
+	// same stats, different body: skipped
+	m.Raw = []byte(`{"n":2}`)
+	if err := s.UpdatePull(ctx, id, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := raw(); got != `{"n":1}` {
+		t.Errorf("raw=%q, want the unchanged pull left alone", got)
+	}
+
+	// merged: written
+	merged := "2024-01-02T00:00:00Z"
+	m.State, m.MergedAt = "closed", &merged
+	if err := s.UpdatePull(ctx, id, m); err != nil {
+		t.Fatal(err)
+	}
+	if got := raw(); got != `{"n":2}` {
+		t.Errorf("raw=%q, want the merged pull written", got)
+	}
+}
+
+func TestPrefixErr(t *testing.T) {
+	for _, c := range []struct {
+		prefix string
+		ok     bool
+	}{
+		{"", true},
+		{"prism_", true},
+		{"_x1", true},
+		{"1x", false},
+		{"x'", false},
+		{`x"`, false},
+		{"x;DROP TABLE commits;", false},
+		{"x y", false},
+		{"x-y", false},
+		{strings.Repeat("x", maxPrefix), true},
+		{strings.Repeat("x", maxPrefix+1), false},
+	} {
+		if err := prefixErr(c.prefix); (err == nil) != c.ok {
+			t.Errorf("prefixErr(%q)=%v, want ok=%v", c.prefix, err, c.ok)
+		}
+	}
+}
+
+func TestPrefixedStore(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(&Config{TablePrefix: "prism_"}, "sqlite:"+t.TempDir()+"/prism.db")
+	defer s.db.Close()
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.FindOrCreateCommit(ctx, "o", "r", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM prism_commits").Scan(&n); err != nil || n != 1 {
+		t.Errorf("prism_commits has %v rows, err=%v, want 1", n, err)
+	}
+}
+
+// org and repo names only ever reach sql as parameters, so even names
+// --repos would refuse can't change a query
+func TestRepoParameters(t *testing.T) {
+	ctx := context.Background()
+	s := testSqlStore(t)
+	for _, repo := range []string{"r", "r'; DROP TABLE commits; --", `r" OR 1=1 --`} {
+		if _, err := s.FindOrCreateCommit(ctx, "o", repo, "abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repos, err := s.QueryRepos(ctx, "o")
+	if err != nil || len(repos) != 3 {
+		t.Fatalf("repos=%q err=%v, want all 3", repos, err)
+	}
+	if n, err := s.DeleteRepo(ctx, "o", `r" OR 1=1 --`); err != nil || n != 1 {
+		t.Errorf("deleted %v err=%v, want just the 1 commit", n, err)
+	}
+	if repos, _ := s.QueryRepos(ctx, "o"); len(repos) != 2 {
+		t.Errorf("repos=%q, want the other 2 left", repos)
+	}
+}
+
+func TestPendingSkipsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	s := testSqlStore(t)
+	for _, sha := range []string{"a", "b"} {
+		if _, err := s.FindOrCreateCommit(ctx, "o", "r", sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := s.QueryPendingCommits(ctx, "o", "", 10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("pending=%v err=%v, want both shas", pending, err)
+	}
+	if dead, err := s.FailedLookup(ctx, "commit", pending[0].Id, "o", "r", "u", "e", 1); err != nil || !dead {
+		t.Fatalf("dead=%v err=%v, want dead lettered", dead, err)
+	}
+
+	left, err := s.QueryPendingCommits(ctx, "o", "", 10)
+	if err != nil || len(left) != 1 || left[0].Id != pending[1].Id {
+		t.Errorf("pending=%v err=%v, want just %v", left, err, pending[1].Id)
+	}
+}
+
+// repos with nothing but, say, topics or dead letters are still listed, so
+// --deleter can drop them once they leave the org
+func TestQueryReposEveryTable(t *testing.T) {
+	ctx := context.Background()
+	s := testSqlStore(t)
+	if _, err := s.FindOrCreateCommit(ctx, "o", "a", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRepoTopics(ctx, "o", "b", []string{"go"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FailedLookup(ctx, "pull", "1", "o", "c", "u", "e", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveEtag(ctx, "https://api.github.com/repos/o/d/commits?per_page=100", `"x"`); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := s.QueryRepos(ctx, "o")
+	if err != nil || strings.Join(repos, ",") != "a,b,c,d" {
+		t.Errorf("repos=%q err=%v, want a,b,c,d", repos, err)
+	}
+}
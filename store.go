@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Store keeps what prism harvests; handlers only reach the database
+// through one
+type Store interface {
+	// FindOrCreateCommit inserts sha unless it's there, reporting whether it did
+	FindOrCreateCommit(ctx context.Context, org, repo, sha string) (bool, error)
+	UpdateCommit(ctx context.Context, id string, m commitMeta) error
+	CreateCommitFiles(ctx context.Context, id string, files []commitFile) error
+	// CreateCommitParents replaces the parent shas of id, first parent first
+	CreateCommitParents(ctx context.Context, id string, parents []string) error
+	MissingCommit(ctx context.Context, id string) error
+	ResetMissingCommits(ctx context.Context, org string) (int64, error)
+	// QueryPendingCommits pages through pending shas by id, starting past after
+	QueryPendingCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error)
+	// QueryUncheckedCommits pages through shas without check runs yet, like
+	// QueryPendingCommits
+	QueryUncheckedCommits(ctx context.Context, org, after string, limit int) ([]pendingCommit, error)
+	// SaveCheckRuns replaces the check runs of id, marking it checked
+	SaveCheckRuns(ctx context.Context, id string, runs []checkRun) error
+	// LatestCommitDate is repo's newest stored committer date, empty if none
+	LatestCommitDate(ctx context.Context, org, repo string) (string, error)
+
+	// FindOrCreatePull inserts number unless it's there, reporting whether it did
+	FindOrCreatePull(ctx context.Context, org, repo string, number int) (bool, error)
+	UpdatePull(ctx context.Context, id string, m pullMeta) error
+	// FindPull is the id of pull number, empty if it isn't there
+	FindPull(ctx context.Context, org, repo string, number int) (string, error)
+	QueryPendingPulls(ctx context.Context, org, after string, limit int) ([]pendingPull, error)
+
+	SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) error
+	SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) error
+	SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) error
+	SaveDeployment(ctx context.Context, org, repo string, d deployment) error
+	SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, s deploymentStatus) error
+	SaveTeam(ctx context.Context, org, slug, name string) error
+	// SaveTeamMembers replaces the logins in team slug
+	SaveTeamMembers(ctx context.Context, org, slug string, logins []string) error
+	// SaveRepoLanguages replaces repo's bytes per language
+	SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) error
+	// SaveRepoTopics replaces repo's topics
+	SaveRepoTopics(ctx context.Context, org, repo string, topics []string) error
+
+	// QueryRepos lists the distinct repos with rows for org
+	QueryRepos(ctx context.Context, org string) ([]string, error)
+	// DeleteRepo removes every row for repo, returning how many went
+	DeleteRepo(ctx context.Context, org, repo string) (int64, error)
+
+	// FailedLookup counts a failed lookup of the kind row id, dead lettering
+	// it once attempts reach max; reports whether it's dead
+	FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (bool, error)
+	// RetryDeadLetters clears org's dead letters so they're pending again
+	RetryDeadLetters(ctx context.Context, org string) (int64, error)
+
+	// SaveProgress replaces the repo listing cursor of orgs, clearing it
+	// when p has no org
+	SaveProgress(ctx context.Context, orgs []string, p state) error
+	// LoadProgress is the saved cursor for one of orgs, empty if none
+	LoadProgress(ctx context.Context, orgs []string) (state, error)
+	// SaveBackfill records how far repo's --backfill of since..until got
+	SaveBackfill(ctx context.Context, org, repo, since, until, done string) error
+	// LoadBackfill is how far repo's backfill of since..until got, empty if
+	// it hasn't started or was of another window
+	LoadBackfill(ctx context.Context, org, repo, since, until string) (string, error)
+	// SaveEtag records url's etag, once its response has been written
+	SaveEtag(ctx context.Context, url, etag string) error
+	// LoadEtag is url's recorded etag, empty if none
+	LoadEtag(ctx context.Context, url string) (string, error)
+
+	Migrate(ctx context.Context) error
+}
+
+// sha still needing metadata
+type pendingCommit struct {
+	Id   string
+	Repo string
+	Sha  string
+}
+
+// pull still needing metadata
+type pendingPull struct {
+	Id     string
+	Repo   string
+	Number int
+}
+
+// metadata from a single commit lookup
+type commitMeta struct {
+	Email              string          `json:"email"`
+	Date               string          `json:"date"`
+	Message            string          `json:"message"`
+	Additions          int             `json:"additions"`
+	Deletions          int             `json:"deletions"`
+	Total              int             `json:"total"`
+	Name               string          `json:"name"`
+	Login              *string         `json:"login"` // nil, stored as NULL, for commits with no github account
+	CommitterEmail     string          `json:"committer_email"`
+	CommitterDate      string          `json:"committer_date"`
+	Verified           bool            `json:"verified"`
+	VerificationReason string          `json:"verification_reason"`
+	Raw                json.RawMessage `json:"raw,omitempty"` // whole response, with --raw
+}
+
+// file changed by a commit
+// http://developer.github.com/v3/repos/commits/#get-a-single-commit
+type commitFile struct {
+	Filename  string `json:"filename"`
+	Status    string `json:"status"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+}
+
+// metadata from a single pull lookup
+type pullMeta struct {
+	Title     string          `json:"title"`
+	Comments  int             `json:"comments"`
+	Commits   int             `json:"commits"`
+	Additions int             `json:"additions"`
+	Deletions int             `json:"deletions"`
+	Changed   int             `json:"changed_files"`
+	State     string          `json:"state"`
+	Login     string          `json:"login"`
+	CreatedAt string          `json:"created_at"`
+	MergedAt  *string         `json:"merged_at"` // nil, stored as NULL, while a pull is open
+	ClosedAt  *string         `json:"closed_at"`
+	Raw       json.RawMessage `json:"raw,omitempty"` // whole response, with --raw
+}
+
+type dependabotAlert struct {
+	Number    int    `json:"number"`
+	Package   string `json:"package"`
+	Severity  string `json:"severity"`
+	State     string `json:"state"`
+	CreatedAt string `json:"created_at"`
+}
+
+type codeScanningAlert struct {
+	Number   int    `json:"number"`
+	RuleId   string `json:"rule_id"`
+	Severity string `json:"severity"`
+	State    string `json:"state"`
+	Tool     string `json:"tool"`
+}
+
+// check run on a commit
+type checkRun struct {
+	Name        string  `json:"name"`
+	Status      string  `json:"status"`
+	Conclusion  *string `json:"conclusion"` // nil until the check completes
+	StartedAt   *string `json:"started_at"`
+	CompletedAt *string `json:"completed_at"`
+}
+
+// github actions run
+type workflowRun struct {
+	RunId      int64   `json:"run_id"`
+	Workflow   string  `json:"workflow"`
+	Event      string  `json:"event"`
+	Status     string  `json:"status"`
+	Conclusion *string `json:"conclusion"` // nil until the run completes
+	HeadSha    string  `json:"head_sha"`
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// deployment, with the state of its newest status
+type deployment struct {
+	DeploymentId int64  `json:"deployment_id"`
+	Environment  string `json:"environment"`
+	Ref          string `json:"ref"`
+	Sha          string `json:"sha"`
+	Creator      string `json:"creator"`
+	CreatedAt    string `json:"created_at"`
+	State        string `json:"state"`
+}
+
+type deploymentStatus struct {
+	StatusId  int64  `json:"status_id"`
+	State     string `json:"state"`
+	Creator   string `json:"creator"`
+	CreatedAt string `json:"created_at"`
+}
+
+// logs writes rather than making them, reading through to the wrapped store
+type dryStore struct {
+	Store
+}
+
+func (s dryStore) FindOrCreateCommit(ctx context.Context, org, repo, sha string) (bool, error) {
+	infof("fn=FindOrCreateCommit org=%v repo=%v sha=%v at=dry-run\n", org, repo, sha)
+	return false, nil
+}
+
+func (s dryStore) UpdateCommit(ctx context.Context, id string, m commitMeta) error {
+	infof("fn=UpdateCommit id=%v email=%v date=%v adds=%v dels=%v total=%v name=%q at=dry-run\n", id, m.Email, m.Date, m.Additions, m.Deletions, m.Total, m.Name)
+	return nil
+}
+
+func (s dryStore) CreateCommitFiles(ctx context.Context, id string, files []commitFile) error {
+	infof("fn=CreateCommitFiles id=%v files=%v at=dry-run\n", id, len(files))
+	return nil
+}
+
+func (s dryStore) CreateCommitParents(ctx context.Context, id string, parents []string) error {
+	infof("fn=CreateCommitParents id=%v parents=%v at=dry-run\n", id, len(parents))
+	return nil
+}
+
+func (s dryStore) SaveCheckRuns(ctx context.Context, id string, runs []checkRun) error {
+	infof("fn=SaveCheckRuns id=%v check_runs=%v at=dry-run\n", id, len(runs))
+	return nil
+}
+
+func (s dryStore) MissingCommit(ctx context.Context, id string) error {
+	infof("fn=MissingCommit id=%v at=dry-run\n", id)
+	return nil
+}
+
+func (s dryStore) ResetMissingCommits(ctx context.Context, org string) (int64, error) {
+	infof("fn=ResetMissingCommits org=%v at=dry-run\n", org)
+	return 0, nil
+}
+
+func (s dryStore) FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (bool, error) {
+	infof("fn=FailedLookup kind=%v id=%v org=%v repo=%v url=%q err=%q at=dry-run\n", kind, id, org, repo, url, lastErr)
+	return false, nil
+}
+
+func (s dryStore) RetryDeadLetters(ctx context.Context, org string) (int64, error) {
+	infof("fn=RetryDeadLetters org=%v at=dry-run\n", org)
+	return 0, nil
+}
+
+func (s dryStore) SaveProgress(ctx context.Context, orgs []string, p state) error {
+	infof("fn=SaveProgress org=%v repo=%v url=%q at=dry-run\n", p.Org, p.Repo, p.Url)
+	return nil
+}
+
+func (s dryStore) SaveBackfill(ctx context.Context, org, repo, since, until, done string) error {
+	infof("fn=SaveBackfill org=%v repo=%v since=%v until=%v done=%v at=dry-run\n", org, repo, since, until, done)
+	return nil
+}
+
+func (s dryStore) SaveEtag(ctx context.Context, url, etag string) error {
+	infof("fn=SaveEtag url=%q etag=%q at=dry-run\n", url, etag)
+	return nil
+}
+
+func (s dryStore) FindOrCreatePull(ctx context.Context, org, repo string, number int) (bool, error) {
+	infof("fn=FindOrCreatePull org=%v repo=%v number=%v at=dry-run\n", org, repo, number)
+	return false, nil
+}
+
+func (s dryStore) UpdatePull(ctx context.Context, id string, m pullMeta) error {
+	infof("fn=UpdatePull id=%v title=%q comments=%v commits=%v adds=%v dels=%v changed=%v state=%v login=%v at=dry-run\n", id, m.Title, m.Comments, m.Commits, m.Additions, m.Deletions, m.Changed, m.State, m.Login)
+	return nil
+}
+
+func (s dryStore) SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) error {
+	infof("fn=SaveDependabotAlert org=%v repo=%v number=%v state=%v at=dry-run\n", org, repo, a.Number, a.State)
+	return nil
+}
+
+func (s dryStore) SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) error {
+	infof("fn=SaveCodeScanningAlert org=%v repo=%v number=%v state=%v at=dry-run\n", org, repo, a.Number, a.State)
+	return nil
+}
+
+func (s dryStore) SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) error {
+	infof("fn=SaveWorkflowRun org=%v repo=%v run_id=%v status=%v at=dry-run\n", org, repo, r.RunId, r.Status)
+	return nil
+}
+
+func (s dryStore) SaveDeployment(ctx context.Context, org, repo string, d deployment) error {
+	infof("fn=SaveDeployment org=%v repo=%v deployment_id=%v state=%v at=dry-run\n", org, repo, d.DeploymentId, d.State)
+	return nil
+}
+
+func (s dryStore) SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, st deploymentStatus) error {
+	infof("fn=SaveDeploymentStatus org=%v repo=%v deployment_id=%v status_id=%v at=dry-run\n", org, repo, deploymentId, st.StatusId)
+	return nil
+}
+
+func (s dryStore) SaveTeam(ctx context.Context, org, slug, name string) error {
+	infof("fn=SaveTeam org=%v slug=%v at=dry-run\n", org, slug)
+	return nil
+}
+
+func (s dryStore) SaveTeamMembers(ctx context.Context, org, slug string, logins []string) error {
+	infof("fn=SaveTeamMembers org=%v slug=%v members=%v at=dry-run\n", org, slug, len(logins))
+	return nil
+}
+
+func (s dryStore) SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) error {
+	infof("fn=SaveRepoLanguages org=%v repo=%v languages=%v at=dry-run\n", org, repo, len(langs))
+	return nil
+}
+
+func (s dryStore) SaveRepoTopics(ctx context.Context, org, repo string, topics []string) error {
+	infof("fn=SaveRepoTopics org=%v repo=%v topics=%v at=dry-run\n", org, repo, len(topics))
+	return nil
+}
+
+func (s dryStore) DeleteRepo(ctx context.Context, org, repo string) (int64, error) {
+	infof("fn=DeleteRepo org=%v repo=%v at=dry-run\n", org, repo)
+	return 0, nil
+}
+
+func (s dryStore) Migrate(ctx context.Context) error {
+	infof("fn=Migrate at=dry-run\n")
+	return nil
+}
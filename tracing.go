@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans go nowhere until startTracing sets a provider
+var tracer = otel.Tracer("github.com/mfine/prism")
+
+// export spans over otlp/http to --otel-endpoint, if set; the returned
+// func flushes what's buffered
+func startTracing(ctx context.Context, cfg *Config) func() {
+	if cfg.OtelEndpoint == "" {
+		return func() {}
+	}
+
+	exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OtelEndpoint))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("prism"), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(tp)
+	infof("fn=startTracing endpoint=%v\n", cfg.OtelEndpoint)
+
+	return func() {
+		if err := tp.Shutdown(context.Background()); err != nil {
+			warnf("fn=startTracing err=%v\n", err)
+		}
+	}
+}
+
+// root span for a worker task on repo, carrying the task's --task-timeout
+// deadline for request to hold its api calls to
+func startTask(ctx context.Context, cfg *Config, name, org, repo string) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, name, trace.WithNewRoot(), trace.WithAttributes(attribute.String("org", org), attribute.String("repo", repo)))
+	inFlight.add(org+"/"+repo, 1)
+
+	var deadline time.Time
+	if cfg.TaskTimeout > 0 {
+		deadline = time.Now().Add(cfg.TaskTimeout)
+		ctx = context.WithValue(ctx, deadlineKey{}, deadline)
+	}
+
+	return ctx, taskSpan{span, name, org + "/" + repo, deadline}
+}
+
+// a value rather than the context's own deadline, so store calls finish
+// what they started instead of failing the run
+type deadlineKey struct{}
+
+// task's deadline, false if it has none
+func taskDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(deadlineKey{}).(time.Time)
+	return deadline, ok
+}
+
+// whether the task's deadline has passed
+func expired(ctx context.Context) bool {
+	deadline, ok := taskDeadline(ctx)
+	return ok && time.Now().After(deadline)
+}
+
+// task's span, taking its repo out of flight once ended
+type taskSpan struct {
+	trace.Span
+	name     string
+	repo     string
+	deadline time.Time
+}
+
+func (s taskSpan) End(options ...trace.SpanEndOption) {
+	inFlight.add(s.repo, -1)
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		warnf("fn=%v repo=%v at=task-timeout\n", s.name, s.repo)
+		s.Span.SetStatus(codes.Error, "task timeout")
+	}
+	s.Span.End(options...)
+}
+
+// span for a store call, ended with its error
+func storeSpan(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, "store."+name, trace.WithSpanKind(trace.SpanKindClient))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// wraps every call to a store in a span, with --otel-endpoint
+type tracedStore struct {
+	Store
+}
+
+func (s tracedStore) FindOrCreateCommit(ctx context.Context, org, repo, sha string) (created bool, err error) {
+	ctx, end := storeSpan(ctx, "FindOrCreateCommit")
+	defer func() { end(err) }()
+
+	return s.Store.FindOrCreateCommit(ctx, org, repo, sha)
+}
+
+func (s tracedStore) UpdateCommit(ctx context.Context, id string, m commitMeta) (err error) {
+	ctx, end := storeSpan(ctx, "UpdateCommit")
+	defer func() { end(err) }()
+
+	return s.Store.UpdateCommit(ctx, id, m)
+}
+
+func (s tracedStore) CreateCommitFiles(ctx context.Context, id string, files []commitFile) (err error) {
+	ctx, end := storeSpan(ctx, "CreateCommitFiles")
+	defer func() { end(err) }()
+
+	return s.Store.CreateCommitFiles(ctx, id, files)
+}
+
+func (s tracedStore) CreateCommitParents(ctx context.Context, id string, parents []string) (err error) {
+	ctx, end := storeSpan(ctx, "CreateCommitParents")
+	defer func() { end(err) }()
+
+	return s.Store.CreateCommitParents(ctx, id, parents)
+}
+
+func (s tracedStore) MissingCommit(ctx context.Context, id string) (err error) {
+	ctx, end := storeSpan(ctx, "MissingCommit")
+	defer func() { end(err) }()
+
+	return s.Store.MissingCommit(ctx, id)
+}
+
+func (s tracedStore) ResetMissingCommits(ctx context.Context, org string) (n int64, err error) {
+	ctx, end := storeSpan(ctx, "ResetMissingCommits")
+	defer func() { end(err) }()
+
+	return s.Store.ResetMissingCommits(ctx, org)
+}
+
+func (s tracedStore) FailedLookup(ctx context.Context, kind, id, org, repo, url, lastErr string, max int) (dead bool, err error) {
+	ctx, end := storeSpan(ctx, "FailedLookup")
+	defer func() { end(err) }()
+
+	return s.Store.FailedLookup(ctx, kind, id, org, repo, url, lastErr, max)
+}
+
+func (s tracedStore) RetryDeadLetters(ctx context.Context, org string) (n int64, err error) {
+	ctx, end := storeSpan(ctx, "RetryDeadLetters")
+	defer func() { end(err) }()
+
+	return s.Store.RetryDeadLetters(ctx, org)
+}
+
+func (s tracedStore) SaveProgress(ctx context.Context, orgs []string, p state) (err error) {
+	ctx, end := storeSpan(ctx, "SaveProgress")
+	defer func() { end(err) }()
+
+	return s.Store.SaveProgress(ctx, orgs, p)
+}
+
+func (s tracedStore) LoadProgress(ctx context.Context, orgs []string) (p state, err error) {
+	ctx, end := storeSpan(ctx, "LoadProgress")
+	defer func() { end(err) }()
+
+	return s.Store.LoadProgress(ctx, orgs)
+}
+
+func (s tracedStore) SaveBackfill(ctx context.Context, org, repo, since, until, done string) (err error) {
+	ctx, end := storeSpan(ctx, "SaveBackfill")
+	defer func() { end(err) }()
+
+	return s.Store.SaveBackfill(ctx, org, repo, since, until, done)
+}
+
+func (s tracedStore) LoadBackfill(ctx context.Context, org, repo, since, until string) (done string, err error) {
+	ctx, end := storeSpan(ctx, "LoadBackfill")
+	defer func() { end(err) }()
+
+	return s.Store.LoadBackfill(ctx, org, repo, since, until)
+}
+
+func (s tracedStore) SaveEtag(ctx context.Context, url, etag string) (err error) {
+	ctx, end := storeSpan(ctx, "SaveEtag")
+	defer func() { end(err) }()
+
+	return s.Store.SaveEtag(ctx, url, etag)
+}
+
+func (s tracedStore) LoadEtag(ctx context.Context, url string) (etag string, err error) {
+	ctx, end := storeSpan(ctx, "LoadEtag")
+	defer func() { end(err) }()
+
+	return s.Store.LoadEtag(ctx, url)
+}
+
+func (s tracedStore) QueryPendingCommits(ctx context.Context, org, after string, limit int) (pending []pendingCommit, err error) {
+	ctx, end := storeSpan(ctx, "QueryPendingCommits")
+	defer func() { end(err) }()
+
+	return s.Store.QueryPendingCommits(ctx, org, after, limit)
+}
+
+func (s tracedStore) QueryUncheckedCommits(ctx context.Context, org, after string, limit int) (pending []pendingCommit, err error) {
+	ctx, end := storeSpan(ctx, "QueryUncheckedCommits")
+	defer func() { end(err) }()
+
+	return s.Store.QueryUncheckedCommits(ctx, org, after, limit)
+}
+
+func (s tracedStore) SaveCheckRuns(ctx context.Context, id string, runs []checkRun) (err error) {
+	ctx, end := storeSpan(ctx, "SaveCheckRuns")
+	defer func() { end(err) }()
+
+	return s.Store.SaveCheckRuns(ctx, id, runs)
+}
+
+func (s tracedStore) LatestCommitDate(ctx context.Context, org, repo string) (date string, err error) {
+	ctx, end := storeSpan(ctx, "LatestCommitDate")
+	defer func() { end(err) }()
+
+	return s.Store.LatestCommitDate(ctx, org, repo)
+}
+
+func (s tracedStore) FindOrCreatePull(ctx context.Context, org, repo string, number int) (created bool, err error) {
+	ctx, end := storeSpan(ctx, "FindOrCreatePull")
+	defer func() { end(err) }()
+
+	return s.Store.FindOrCreatePull(ctx, org, repo, number)
+}
+
+func (s tracedStore) UpdatePull(ctx context.Context, id string, m pullMeta) (err error) {
+	ctx, end := storeSpan(ctx, "UpdatePull")
+	defer func() { end(err) }()
+
+	return s.Store.UpdatePull(ctx, id, m)
+}
+
+func (s tracedStore) FindPull(ctx context.Context, org, repo string, number int) (id string, err error) {
+	ctx, end := storeSpan(ctx, "FindPull")
+	defer func() { end(err) }()
+
+	return s.Store.FindPull(ctx, org, repo, number)
+}
+
+func (s tracedStore) QueryPendingPulls(ctx context.Context, org, after string, limit int) (pending []pendingPull, err error) {
+	ctx, end := storeSpan(ctx, "QueryPendingPulls")
+	defer func() { end(err) }()
+
+	return s.Store.QueryPendingPulls(ctx, org, after, limit)
+}
+
+func (s tracedStore) SaveDependabotAlert(ctx context.Context, org, repo string, a dependabotAlert) (err error) {
+	ctx, end := storeSpan(ctx, "SaveDependabotAlert")
+	defer func() { end(err) }()
+
+	return s.Store.SaveDependabotAlert(ctx, org, repo, a)
+}
+
+func (s tracedStore) SaveCodeScanningAlert(ctx context.Context, org, repo string, a codeScanningAlert) (err error) {
+	ctx, end := storeSpan(ctx, "SaveCodeScanningAlert")
+	defer func() { end(err) }()
+
+	return s.Store.SaveCodeScanningAlert(ctx, org, repo, a)
+}
+
+func (s tracedStore) SaveWorkflowRun(ctx context.Context, org, repo string, r workflowRun) (err error) {
+	ctx, end := storeSpan(ctx, "SaveWorkflowRun")
+	defer func() { end(err) }()
+
+	return s.Store.SaveWorkflowRun(ctx, org, repo, r)
+}
+
+func (s tracedStore) SaveDeployment(ctx context.Context, org, repo string, d deployment) (err error) {
+	ctx, end := storeSpan(ctx, "SaveDeployment")
+	defer func() { end(err) }()
+
+	return s.Store.SaveDeployment(ctx, org, repo, d)
+}
+
+func (s tracedStore) SaveDeploymentStatus(ctx context.Context, org, repo string, deploymentId int64, st deploymentStatus) (err error) {
+	ctx, end := storeSpan(ctx, "SaveDeploymentStatus")
+	defer func() { end(err) }()
+
+	return s.Store.SaveDeploymentStatus(ctx, org, repo, deploymentId, st)
+}
+
+func (s tracedStore) SaveTeam(ctx context.Context, org, slug, name string) (err error) {
+	ctx, end := storeSpan(ctx, "SaveTeam")
+	defer func() { end(err) }()
+
+	return s.Store.SaveTeam(ctx, org, slug, name)
+}
+
+func (s tracedStore) SaveTeamMembers(ctx context.Context, org, slug string, logins []string) (err error) {
+	ctx, end := storeSpan(ctx, "SaveTeamMembers")
+	defer func() { end(err) }()
+
+	return s.Store.SaveTeamMembers(ctx, org, slug, logins)
+}
+
+func (s tracedStore) SaveRepoLanguages(ctx context.Context, org, repo string, langs map[string]int) (err error) {
+	ctx, end := storeSpan(ctx, "SaveRepoLanguages")
+	defer func() { end(err) }()
+
+	return s.Store.SaveRepoLanguages(ctx, org, repo, langs)
+}
+
+func (s tracedStore) SaveRepoTopics(ctx context.Context, org, repo string, topics []string) (err error) {
+	ctx, end := storeSpan(ctx, "SaveRepoTopics")
+	defer func() { end(err) }()
+
+	return s.Store.SaveRepoTopics(ctx, org, repo, topics)
+}
+
+func (s tracedStore) QueryRepos(ctx context.Context, org string) (repos []string, err error) {
+	ctx, end := storeSpan(ctx, "QueryRepos")
+	defer func() { end(err) }()
+
+	return s.Store.QueryRepos(ctx, org)
+}
+
+func (s tracedStore) DeleteRepo(ctx context.Context, org, repo string) (n int64, err error) {
+	ctx, end := storeSpan(ctx, "DeleteRepo")
+	defer func() { end(err) }()
+
+	return s.Store.DeleteRepo(ctx, org, repo)
+}
+
+func (s tracedStore) Migrate(ctx context.Context) (err error) {
+	ctx, end := storeSpan(ctx, "Migrate")
+	defer func() { end(err) }()
+
+	return s.Store.Migrate(ctx)
+}
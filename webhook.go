@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// deliveries remembered, so a redelivery is acknowledged without redoing it
+	webhookSeen = 1000
+	// github caps payloads at 25MB
+	webhookMaxBody = 25 << 20
+)
+
+// recent X-GitHub-Delivery ids, oldest first
+type deliveries struct {
+	sync.Mutex
+	ids  []string
+	seen map[string]bool
+}
+
+// reports whether id was already seen, remembering it if not
+func (d *deliveries) dup(id string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if id == "" {
+		return false
+	}
+	if d.seen[id] {
+		return true
+	}
+	d.seen[id] = true
+	if d.ids = append(d.ids, id); len(d.ids) > webhookSeen {
+		delete(d.seen, d.ids[0])
+		d.ids = d.ids[1:]
+	}
+
+	return false
+}
+
+// drop id, so github's redelivery of a delivery that couldn't be taken
+// isn't mistaken for a duplicate
+func (d *deliveries) forget(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.seen[id] {
+		return
+	}
+	delete(d.seen, id)
+	for i, seen := range d.ids {
+		if seen == id {
+			d.ids = append(d.ids[:i], d.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// the parts of push and pull_request events used
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads
+type webhookEvent struct {
+	Repository struct {
+		Name  string
+		Owner struct {
+			Login string
+		}
+	}
+	Number int
+}
+
+// receive github's push and pull_request events on --webhook-addr instead
+// of polling the org: a push lists the repo's commits, a pull_request looks
+// the pull up; the updater fills in commit metadata as usual
+func serveWebhook(ctx context.Context, cfg *Config, st Store, c chan<- func()) {
+	mux := webhookHandler(ctx, cfg, st, c)
+
+	go func() {
+		infof("fn=serveWebhook addr=%v\n", cfg.WebhookAddr)
+		log.Fatal(http.ListenAndServe(cfg.WebhookAddr, mux))
+	}()
+}
+
+// verify, dedupe, and enqueue deliveries
+func webhookHandler(ctx context.Context, cfg *Config, st Store, c chan<- func()) http.Handler {
+	orgs := make(map[string]bool)
+	for _, org := range cfg.Orgs {
+		orgs[org] = true
+	}
+	d := &deliveries{seen: make(map[string]bool)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+		if err != nil {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+
+		// nothing about the payload is trusted, or even parsed, until it's
+		// known to be github's
+		if !validSignature(cfg.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+			warnf("fn=webhook remote=%v at=bad-signature\n", r.RemoteAddr)
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		kind, delivery := r.Header.Get("X-GitHub-Event"), r.Header.Get("X-GitHub-Delivery")
+		if d.dup(delivery) {
+			debugf("fn=webhook event=%v delivery=%v at=duplicate\n", kind, delivery)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var e webhookEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			warnf("fn=webhook event=%v delivery=%v err=%v\n", kind, delivery, err)
+			d.forget(delivery)
+			http.Error(w, "bad payload", http.StatusBadRequest)
+			return
+		}
+		org, repo := e.Repository.Owner.Login, e.Repository.Name
+		if !orgs[org] || !wanted(cfg, repo) {
+			debugf("fn=webhook event=%v org=%v repo=%v at=skip\n", kind, org, repo)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		infof("fn=webhook event=%v org=%v repo=%v delivery=%v\n", kind, org, repo, delivery)
+		var f func()
+		switch kind {
+		case "push":
+			f = bounded(func() { commits(ctx, cfg, st, org, repo) })
+		case "pull_request":
+			f = func() { webhookPull(ctx, cfg, st, org, repo, e.Number) }
+		}
+
+		// never block the response on a full queue, github gives up after
+		// 10s; a 503 without the delivery remembered lets it be redelivered
+		if f != nil {
+			select {
+			case c <- f:
+			default:
+				warnf("fn=webhook event=%v org=%v repo=%v delivery=%v at=queue-full\n", kind, org, repo, delivery)
+				d.forget(delivery)
+				http.Error(w, "queue full", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	return mux
+}
+
+// sig is X-Hub-Signature-256, the hex hmac-sha256 of body keyed by
+// WEBHOOK_SECRET; compared in constant time so it can't be guessed a byte
+// at a time
+func validSignature(secret, body []byte, sig string) bool {
+	if !strings.HasPrefix(sig, "sha256=") {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), got)
+}
+
+// add the pull if new, then look it up, as the event may be a merge or
+// close of one already stored
+func webhookPull(ctx context.Context, cfg *Config, st Store, org, repo string, number int) {
+	created, err := st.FindOrCreatePull(ctx, org, repo, number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if created {
+		pullsInserted.Inc()
+		atomic.AddInt64(&stats.pullsFound, 1)
+	}
+
+	id, err := st.FindPull(ctx, org, repo, number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if id != "" {
+		pull(ctx, cfg, st, org, id, repo, number, created)
+	}
+}
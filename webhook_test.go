@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// X-Hub-Signature-256 for body, as github signs it
+func sign(secret []byte, body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	secret, body := []byte("s3cret"), []byte(`{"zen": "Keep it logically awesome."}`)
+	for _, c := range []struct {
+		name string
+		sig  string
+		want bool
+	}{
+		{"valid", sign(secret, string(body)), true},
+		{"other secret", sign([]byte("other"), string(body)), false},
+		{"other body", sign(secret, "{}"), false},
+		{"sha1", "sha1=" + strings.TrimPrefix(sign(secret, string(body)), "sha256="), false},
+		{"not hex", "sha256=zz", false},
+		{"missing", "", false},
+	} {
+		if got := validSignature(secret, body, c.sig); got != c.want {
+			t.Errorf("%v: validSignature=%v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	cfg := &Config{Orgs: []string{"o"}, WebhookSecret: []byte("s3cret")}
+	c := make(chan func(), 1)
+	srv := httptest.NewServer(webhookHandler(context.Background(), cfg, newMemStore(), c))
+	defer srv.Close()
+
+	body := `{"repository": {"name": "r", "owner": {"login": "o"}}}`
+	for _, d := range []struct {
+		name     string
+		sig      string
+		delivery string
+		want     int
+		enqueued int
+	}{
+		{"bad signature", sign([]byte("wrong"), body), "1", http.StatusUnauthorized, 0},
+		{"no signature", "", "2", http.StatusUnauthorized, 0},
+		{"valid", sign(cfg.WebhookSecret, body), "3", http.StatusAccepted, 1},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", d.delivery)
+		if d.sig != "" {
+			req.Header.Set("X-Hub-Signature-256", d.sig)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != d.want || len(c) != d.enqueued {
+			t.Errorf("%v: status=%v enqueued=%v, want %v and %v", d.name, resp.StatusCode, len(c), d.want, d.enqueued)
+		}
+	}
+}
+
+// a full queue answers 503 straight away, and the delivery is taken once
+// there's room again rather than acknowledged as a duplicate
+func TestWebhookQueueFull(t *testing.T) {
+	cfg := &Config{Orgs: []string{"o"}, WebhookSecret: []byte("s3cret")}
+	c := make(chan func(), 1)
+	c <- func() {}
+	srv := httptest.NewServer(webhookHandler(context.Background(), cfg, newMemStore(), c))
+	defer srv.Close()
+
+	body := `{"repository": {"name": "r", "owner": {"login": "o"}}}`
+	deliver := func() int {
+		req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", "1")
+		req.Header.Set("X-Hub-Signature-256", sign(cfg.WebhookSecret, body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := deliver(); status != http.StatusServiceUnavailable {
+		t.Errorf("status=%v with the queue full, want 503", status)
+	}
+	<-c
+	if status := deliver(); status != http.StatusAccepted || len(c) != 1 {
+		t.Errorf("status=%v enqueued=%v on redelivery, want 202 and 1", status, len(c))
+	}
+}